@@ -29,7 +29,8 @@ and command line flags) have been applied.`,
 		// The private field processedIncludes will be automatically excluded
 		// since it's not exported and the TOML encoder only encodes exported fields
 		encoder := toml.NewEncoder(&buf)
-		err := encoder.Encode(cfg)
+		// Password hashes are redacted
+		err := encoder.Encode(cfg.Redacted())
 		if err != nil {
 			return fmt.Errorf("error encoding configuration: %w", err)
 		}
@@ -0,0 +1,122 @@
+package ldapenforcer
+
+import (
+	"fmt"
+
+	"github.com/mrled/ldapenforcer/internal/ldap"
+	"github.com/mrled/ldapenforcer/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+// planCmd represents the plan command
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Compute the changes a sync would make and save them to a plan file",
+	Long: `Computes everything a sync would do and writes it to a JSON plan file
+without making any changes. The plan records a hash of the configuration
+and a fingerprint of each target entry, so that "apply" can refuse to run
+if the directory has changed since the plan was made.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cfg == nil {
+			return fmt.Errorf("no configuration loaded")
+		}
+
+		// Validate configuration
+		err := cfg.Validate()
+		if err != nil {
+			return fmt.Errorf("configuration is invalid: %w", err)
+		}
+
+		outFile, _ := cmd.Flags().GetString("out")
+
+		// Create LDAP client
+		client, err := ldap.NewClient(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create LDAP client: %w", err)
+		}
+
+		// Ensure connection is closed at the end of the operation
+		defer func() {
+			if closeErr := client.Close(); closeErr != nil {
+				logging.DefaultLogger.Warn("Error closing LDAP connection: %v", closeErr)
+			}
+		}()
+
+		plan, err := client.PlanSync()
+		if err != nil {
+			return fmt.Errorf("failed to compute sync plan: %w", err)
+		}
+
+		err = plan.WritePlanFile(outFile)
+		if err != nil {
+			return err
+		}
+
+		// Don't mix the human-readable plan into a plan written to stdout
+		if outFile != "-" {
+			printSyncPlan(plan)
+			fmt.Printf("Plan written to %s\n", outFile)
+		}
+		return nil
+	},
+}
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply a plan file created by the plan command",
+	Long: `Executes exactly the changes recorded in a plan file.
+Refuses to make any change if an entry in the enforced OUs or the
+configuration has changed since the plan was made.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cfg == nil {
+			return fmt.Errorf("no configuration loaded")
+		}
+
+		// Validate configuration
+		err := cfg.Validate()
+		if err != nil {
+			return fmt.Errorf("configuration is invalid: %w", err)
+		}
+
+		planFile, _ := cmd.Flags().GetString("plan")
+		plan, err := ldap.ReadPlanFile(planFile)
+		if err != nil {
+			return err
+		}
+
+		// Create LDAP client
+		client, err := ldap.NewClient(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create LDAP client: %w", err)
+		}
+
+		// Ensure connection is closed at the end of the operation
+		defer func() {
+			if closeErr := client.Close(); closeErr != nil {
+				logging.DefaultLogger.Warn("Error closing LDAP connection: %v", closeErr)
+			}
+		}()
+
+		printSyncPlan(plan)
+
+		err = client.ApplyPlan(plan)
+		if err != nil {
+			return fmt.Errorf("failed to apply plan: %w", err)
+		}
+
+		logging.DefaultLogger.Info("Plan from %s applied successfully", planFile)
+		return nil
+	},
+}
+
+func init() {
+	// Add commands to the root command
+	RootCmd.AddCommand(planCmd)
+	RootCmd.AddCommand(applyCmd)
+
+	// Add flags
+	planCmd.Flags().StringP("out", "o", "ldapenforcer.plan.json", "Path to write the plan file to (\"-\" for stdout)")
+	applyCmd.Flags().String("plan", "", "Path to the plan file to apply")
+	_ = applyCmd.MarkFlagRequired("plan")
+}
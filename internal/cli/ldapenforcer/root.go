@@ -118,7 +118,8 @@ func createEmptyConfig() *config.Config {
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		// Errors go to stderr so they do not mix with machine-readable output
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
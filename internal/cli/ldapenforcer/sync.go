@@ -2,8 +2,10 @@ package ldapenforcer
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"sort"
 	"syscall"
 	"time"
 
@@ -34,6 +36,15 @@ var syncCmd = &cobra.Command{
 		pollEnabled, _ := cmd.Flags().GetBool("poll")
 		pollConfigIntervalStr, _ := cmd.Flags().GetString("poll-config-interval")
 		pollLDAPIntervalStr, _ := cmd.Flags().GetString("poll-ldap-interval")
+		ldifOut, _ := cmd.Flags().GetString("ldif-out")
+
+		// In LDIF output mode, write out the changes instead of applying them
+		if ldifOut != "" {
+			if pollEnabled {
+				return fmt.Errorf("--ldif-out cannot be combined with --poll")
+			}
+			return writeSyncLDIF(cfg, ldifOut)
+		}
 
 		// If polling is enabled, run continuously
 		if pollEnabled {
@@ -104,6 +115,11 @@ var syncCmd = &cobra.Command{
 				}
 			}
 
+			// Sync again when an account expires, so that it is locked at the deadline
+			// instead of at the next LDAP poll interval
+			expiryTimer := newExpiryTimer(cfg)
+			defer func() { stopTimer(expiryTimer) }()
+
 			// Main polling loop
 			for {
 				select {
@@ -120,6 +136,10 @@ var syncCmd = &cobra.Command{
 					}
 					fmt.Println("Config files changed, reloading configuration...")
 					if reloadConfig(cmd, dryRun) == nil {
+						// Expiry dates may have changed
+						stopTimer(expiryTimer)
+						expiryTimer = newExpiryTimer(cfg)
+
 						// Run sync with new configuration
 						if err := runSync(cfg, dryRun); err == nil {
 							lastLDAPSync = time.Now()
@@ -155,6 +175,17 @@ var syncCmd = &cobra.Command{
 						ldapTimer.Reset(pollLDAPInterval)
 					}
 
+				case <-timerChannel(expiryTimer):
+					fmt.Println("An account expiry time has passed, syncing...")
+					if err := runSync(cfg, dryRun); err != nil {
+						fmt.Printf("Error during sync triggered by account expiry: %v\n", err)
+						// Retry soon rather than waiting for the next expiry
+						expiryTimer = time.NewTimer(pollConfigInterval)
+					} else {
+						lastLDAPSync = time.Now()
+						expiryTimer = newExpiryTimer(cfg)
+					}
+
 				case <-sigChan:
 					fmt.Println("\nReceived interrupt signal, shutting down...")
 					return nil
@@ -197,6 +228,11 @@ var syncPersonCmd = &cobra.Command{
 			}
 		}()
 
+		// Fill in allocated POSIX numbers and user private groups
+		if err := client.PrepareConfig(); err != nil {
+			return fmt.Errorf("failed to prepare configuration: %w", err)
+		}
+
 		// Ensure OUs exist
 		err = client.EnsureManagedOUsExist()
 		if err != nil {
@@ -210,11 +246,9 @@ var syncPersonCmd = &cobra.Command{
 		if dryRun {
 			fmt.Println("Dry run mode - no changes will be made")
 			dn := client.PersonToDN(uid)
-			attrs := ldap.GetPersonAttributes(person)
+			attrs := client.GetPersonAttributes(person)
 			fmt.Printf("Would create/update person: %s\n", dn)
-			for attr, values := range attrs {
-				fmt.Printf("  %s: %v\n", attr, values)
-			}
+			writeEntryAttributes(os.Stdout, attrs)
 			return nil
 		}
 
@@ -258,6 +292,11 @@ var syncSvcAcctCmd = &cobra.Command{
 			}
 		}()
 
+		// Fill in allocated POSIX numbers and user private groups
+		if err := client.PrepareConfig(); err != nil {
+			return fmt.Errorf("failed to prepare configuration: %w", err)
+		}
+
 		// Ensure OUs exist
 		err = client.EnsureManagedOUsExist()
 		if err != nil {
@@ -271,11 +310,9 @@ var syncSvcAcctCmd = &cobra.Command{
 		if dryRun {
 			fmt.Println("Dry run mode - no changes will be made")
 			dn := client.SvcAcctToDN(uid)
-			attrs := ldap.GetSvcAcctAttributes(svcacct)
+			attrs := client.GetSvcAcctAttributes(svcacct)
 			fmt.Printf("Would create/update service account: %s\n", dn)
-			for attr, values := range attrs {
-				fmt.Printf("  %s: %v\n", attr, values)
-			}
+			writeEntryAttributes(os.Stdout, attrs)
 			return nil
 		}
 
@@ -301,10 +338,6 @@ var syncGroupCmd = &cobra.Command{
 		}
 
 		groupname := args[0]
-		group, ok := cfg.LDAPEnforcer.Group[groupname]
-		if !ok {
-			return fmt.Errorf("group %s not found in configuration", groupname)
-		}
 
 		// Create LDAP client
 		client, err := ldap.NewClient(cfg)
@@ -319,6 +352,17 @@ var syncGroupCmd = &cobra.Command{
 			}
 		}()
 
+		// Fill in allocated POSIX numbers and user private groups
+		if err := client.PrepareConfig(); err != nil {
+			return fmt.Errorf("failed to prepare configuration: %w", err)
+		}
+
+		// User private groups only exist once the configuration is prepared
+		group, ok := cfg.LDAPEnforcer.Group[groupname]
+		if !ok {
+			return fmt.Errorf("group %s not found in configuration", groupname)
+		}
+
 		// Ensure OUs exist
 		err = client.EnsureManagedOUsExist()
 		if err != nil {
@@ -355,13 +399,98 @@ var syncGroupCmd = &cobra.Command{
 
 // simulateSync simulates a sync operation without making changes
 func simulateSync(client *ldap.Client) error {
-	// Show what would be done
-	fmt.Println("Dry run summary:")
-	fmt.Printf("- Would ensure managed OUs exist\n")
-	fmt.Printf("- Would sync %d people\n", len(cfg.LDAPEnforcer.Person))
-	fmt.Printf("- Would sync %d service accounts\n", len(cfg.LDAPEnforcer.SvcAcct))
-	fmt.Printf("- Would sync %d groups\n", len(cfg.LDAPEnforcer.Group))
+	plan, err := client.PlanSync()
+	if err != nil {
+		return fmt.Errorf("failed to compute sync plan: %w", err)
+	}
 
+	fmt.Println("Dry run plan:")
+	printSyncPlan(plan)
+	return nil
+}
+
+// writeEntryAttributes writes the attributes of an entry in name order, redacting secret values
+func writeEntryAttributes(w io.Writer, attrs map[string][]string) {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s: %v\n", name, ldap.RedactValues(name, attrs[name]))
+	}
+}
+
+// printSyncPlan shows the changes in a sync plan with old and new attribute values
+func printSyncPlan(plan *ldap.SyncPlan) {
+	for _, ou := range plan.MissingOUs {
+		fmt.Printf("+ create ou %s\n", ou)
+	}
+	for _, change := range plan.Changes {
+		switch change.Action {
+		case ldap.ChangeCreate:
+			fmt.Printf("+ create %s %s\n", change.EntityType, change.DN)
+			for _, attr := range change.Attributes {
+				fmt.Printf("    %s: %v\n", attr.Name, ldap.RedactValues(attr.Name, attr.New))
+			}
+		case ldap.ChangeModify:
+			fmt.Printf("~ modify %s %s\n", change.EntityType, change.DN)
+			for _, attr := range change.Attributes {
+				fmt.Printf("    %s: %v -> %v\n", attr.Name, ldap.RedactValues(attr.Name, attr.Old), ldap.RedactValues(attr.Name, attr.New))
+			}
+		case ldap.ChangeDelete:
+			fmt.Printf("- delete %s %s\n", change.EntityType, change.DN)
+		case ldap.ChangeQuarantine, ldap.ChangeRestore:
+			fmt.Printf("> %s %s %s -> %s\n", change.Action, change.EntityType, change.DN, change.TargetDN())
+			for _, attr := range change.Attributes {
+				fmt.Printf("    %s: %v -> %v\n", attr.Name, ldap.RedactValues(attr.Name, attr.Old), ldap.RedactValues(attr.Name, attr.New))
+			}
+		case ldap.ChangePurge:
+			fmt.Printf("- purge %s %s\n", change.EntityType, change.DN)
+		}
+	}
+
+	fmt.Printf("Summary: %d to create, %d to modify, %d to delete, %d unchanged\n",
+		plan.Count(ldap.ChangeCreate),
+		plan.Count(ldap.ChangeModify),
+		plan.Count(ldap.ChangeDelete),
+		plan.Count(ldap.ChangeUnchanged))
+	if plan.Count(ldap.ChangeQuarantine)+plan.Count(ldap.ChangeRestore)+plan.Count(ldap.ChangePurge) > 0 {
+		fmt.Printf("Quarantine: %d to quarantine, %d to restore, %d to purge\n",
+			plan.Count(ldap.ChangeQuarantine),
+			plan.Count(ldap.ChangeRestore),
+			plan.Count(ldap.ChangePurge))
+	}
+}
+
+// writeSyncLDIF computes the changes a sync would make and writes them as LDIF instead of applying them
+func writeSyncLDIF(cfg *config.Config, path string) error {
+	// Create LDAP client
+	client, err := ldap.NewClient(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create LDAP client: %w", err)
+	}
+
+	// Ensure connection is closed at the end of the operation
+	defer func() {
+		if closeErr := client.Close(); closeErr != nil {
+			logging.DefaultLogger.Warn("Error closing LDAP connection: %v", closeErr)
+		}
+	}()
+
+	plan, err := client.PlanSync()
+	if err != nil {
+		return fmt.Errorf("failed to compute sync plan: %w", err)
+	}
+
+	err = plan.WriteLDIFFile(path)
+	if err != nil {
+		return err
+	}
+
+	logging.DefaultLogger.Info("Wrote LDIF for %d changes to %s; no changes were made to LDAP",
+		len(plan.Changes)-plan.Count(ldap.ChangeUnchanged), path)
 	return nil
 }
 
@@ -411,6 +540,32 @@ func reloadConfig(cmd *cobra.Command, dryRun bool) error {
 	return nil
 }
 
+// newExpiryTimer returns a timer that fires at the next account expiry time in the configuration,
+// or nil if no account expires in the future
+func newExpiryTimer(cfg *config.Config) *time.Timer {
+	next, ok := cfg.NextExpiry(time.Now())
+	if !ok {
+		return nil
+	}
+	logging.DefaultLogger.Debug("Next account expiry is at %s", next.Format(time.RFC3339))
+	return time.NewTimer(time.Until(next))
+}
+
+// timerChannel returns the channel of a timer, or nil (which never receives) if there is no timer
+func timerChannel(timer *time.Timer) <-chan time.Time {
+	if timer == nil {
+		return nil
+	}
+	return timer.C
+}
+
+// stopTimer stops a timer, which may be nil
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
 // runSync runs a single synchronization operation
 func runSync(cfg *config.Config, dryRun bool) error {
 	logging.DefaultLogger.Debug("Starting LDAP synchronization...")
@@ -454,6 +609,7 @@ func init() {
 
 	// Add flags
 	syncCmd.Flags().Bool("dry-run", false, "Perform a dry run without making changes")
+	syncCmd.Flags().String("ldif-out", "", "Write LDIF change records for all changes to this file (\"-\" for stdout) instead of applying them")
 	syncCmd.Flags().Bool("poll", false, "Enable polling mode to continuously check for config changes")
 	syncCmd.Flags().String("poll-config-interval", "10s", "Interval for --poll mode to check if the config file has changed and sync if so (recommended: \"10s\")")
 	syncCmd.Flags().String("poll-ldap-interval", "24h", "Interval for --poll mode to compare the config file to the LDAP server and sync if different (recommended: \"24h\")")
@@ -0,0 +1,41 @@
+package ldapenforcer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mrled/ldapenforcer/internal/config"
+	"github.com/mrled/ldapenforcer/internal/ldap"
+	"github.com/mrled/ldapenforcer/internal/model"
+)
+
+func TestWriteEntryAttributesRedactsPasswordHash(t *testing.T) {
+	hash := "{SSHA}c2VjcmV0aGFzaHZhbHVlc2FsdA=="
+	client := ldap.NewMockClient(&config.Config{
+		LDAPEnforcer: config.LDAPEnforcerConfig{
+			EnforcedPeopleOU:  "ou=people,dc=example,dc=com",
+			EnforcedSvcAcctOU: "ou=svcaccts,dc=example,dc=com",
+		},
+	})
+
+	entries := map[string]map[string][]string{
+		"person":  client.GetPersonAttributes(&model.Person{Username: "john", CN: "John Doe", PasswordHash: hash}),
+		"svcacct": client.GetSvcAcctAttributes(&model.SvcAcct{Username: "backup", CN: "Backup", PasswordHash: hash}),
+	}
+	for name, attrs := range entries {
+		if len(attrs["userPassword"]) == 0 {
+			t.Fatalf("Expected the %s to have a userPassword attribute, got %v", name, attrs)
+		}
+
+		var buf bytes.Buffer
+		writeEntryAttributes(&buf, attrs)
+
+		if strings.Contains(buf.String(), hash) {
+			t.Errorf("Dry run output for the %s contains the password hash:\n%s", name, buf.String())
+		}
+		if !strings.Contains(buf.String(), "userPassword: ["+model.RedactedPassword+"]") {
+			t.Errorf("Expected a redacted userPassword in the output for the %s:\n%s", name, buf.String())
+		}
+	}
+}
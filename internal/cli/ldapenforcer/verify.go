@@ -1,11 +1,16 @@
 package ldapenforcer
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 
 	ldapv3 "github.com/go-ldap/ldap/v3"
 	"github.com/mrled/ldapenforcer/internal/ldap"
 	"github.com/mrled/ldapenforcer/internal/logging"
+	"github.com/mrled/ldapenforcer/internal/model"
 	"github.com/spf13/cobra"
 )
 
@@ -13,7 +18,11 @@ import (
 var verifyCmd = &cobra.Command{
 	Use:   "verify",
 	Short: "Verify LDAP against configuration",
-	Long:  `Verifies that LDAP directory matches the current configuration without making changes.`,
+	Long: `Verifies that LDAP directory matches the current configuration without making changes.
+
+Every configured person, service account, and group is compared attribute by attribute,
+including the resolved members of each group, and any extra entries in the enforced OUs
+are reported. Exits with a non-zero status if any drift is found.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if cfg == nil {
 			return fmt.Errorf("no configuration loaded")
@@ -25,6 +34,11 @@ var verifyCmd = &cobra.Command{
 			return fmt.Errorf("configuration is invalid: %w", err)
 		}
 
+		format, _ := cmd.Flags().GetString("format")
+		if format != "text" && format != "json" {
+			return fmt.Errorf("invalid format %q (expected text or json)", format)
+		}
+
 		// Create LDAP client
 		client, err := ldap.NewClient(cfg)
 		if err != nil {
@@ -38,57 +52,71 @@ var verifyCmd = &cobra.Command{
 			}
 		}()
 
-		// Check if LDAP is accessible
-		fmt.Println("Verifying LDAP connection...")
-		_, err = client.Search("", "(objectClass=*)", []string{"namingContexts"})
+		// Compute what a sync would change; anything it would change is drift
+		plan, err := client.PlanSync()
 		if err != nil {
-			return fmt.Errorf("LDAP connection failed: %w", err)
+			return fmt.Errorf("failed to compare LDAP to the configuration: %w", err)
 		}
-
-		fmt.Println("LDAP connection successful")
-
-		// Check people
-		fmt.Println("\nVerifying people...")
-		for uid := range cfg.LDAPEnforcer.Person {
-			dn := client.PersonToDN(uid)
-			checkEntity(client, dn, "person")
+		report := ldap.NewDriftReport(plan)
+		for groupname, group := range cfg.LDAPEnforcer.Group {
+			if group.AllowEmpty {
+				// The placeholder that keeps an empty group valid is not a real member
+				report.HideValue("group", groupname, "member", cfg.GetEmptyGroupPlaceholder())
+			}
 		}
 
-		// Check service accounts
-		fmt.Println("\nVerifying service accounts...")
-		for uid := range cfg.LDAPEnforcer.SvcAcct {
-			dn := client.SvcAcctToDN(uid)
-			checkEntity(client, dn, "service account")
+		if format == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				return fmt.Errorf("error encoding drift report: %w", err)
+			}
+		} else {
+			printDriftReport(report)
 		}
 
-		// Check groups
-		fmt.Println("\nVerifying groups...")
-		for groupname := range cfg.LDAPEnforcer.Group {
-			dn := client.GroupToDN(groupname)
-			checkEntity(client, dn, "group")
+		if report.HasDrift() {
+			// Drift is an expected outcome, not a usage error
+			cmd.SilenceUsage = true
+			return fmt.Errorf("drift detected: %d entries differ from the configuration",
+				len(report.Entries)-report.Count(ldap.DriftOK))
 		}
-
-		fmt.Println("\nVerification complete")
 		return nil
 	},
 }
 
-// checkEntity checks if an entity exists in LDAP
-func checkEntity(client *ldap.Client, dn string, entityType string) {
-	exists, _ := client.EntryExists(dn)
-	if exists {
-		fmt.Printf("✓ %s %s exists\n", entityType, dn)
-	} else {
-		fmt.Printf("✗ %s %s does not exist\n", entityType, dn)
+// printDriftReport prints a drift report in human-readable form
+func printDriftReport(report *ldap.DriftReport) {
+	for _, entry := range report.Entries {
+		switch entry.Status {
+		case ldap.DriftOK:
+			fmt.Printf("✓ %s %s matches\n", entry.EntityType, entry.DN)
+		case ldap.DriftMissing:
+			fmt.Printf("✗ %s %s does not exist\n", entry.EntityType, entry.DN)
+		case ldap.DriftChanged:
+			fmt.Printf("✗ %s %s differs\n", entry.EntityType, entry.DN)
+			for _, attr := range entry.Attributes {
+				fmt.Printf("    %s: %v (expected %v)\n", attr.Name, attr.Old, attr.New)
+			}
+		case ldap.DriftExtra:
+			fmt.Printf("✗ %s %s is not in the configuration\n", entry.EntityType, entry.DN)
+		}
 	}
+
+	fmt.Printf("Summary: %d ok, %d missing, %d changed, %d extra\n",
+		report.Count(ldap.DriftOK),
+		report.Count(ldap.DriftMissing),
+		report.Count(ldap.DriftChanged),
+		report.Count(ldap.DriftExtra))
 }
 
 // verifyPersonCmd represents the verify-person command
 var verifyPersonCmd = &cobra.Command{
 	Use:   "verify-person [uid]",
 	Short: "Verify a specific person",
-	Long:  `Verifies that a specific person in LDAP matches the configuration.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Verifies that a specific person in LDAP matches the configuration.
+Exits with a non-zero status if it does not.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if cfg == nil {
 			return fmt.Errorf("no configuration loaded")
@@ -113,6 +141,11 @@ var verifyPersonCmd = &cobra.Command{
 			}
 		}()
 
+		// Fill in allocated POSIX numbers and user private groups
+		if err := client.PrepareConfig(); err != nil {
+			return fmt.Errorf("failed to prepare configuration: %w", err)
+		}
+
 		// Check if person exists
 		dn := client.PersonToDN(uid)
 		exists, err := client.EntryExists(dn)
@@ -121,8 +154,9 @@ var verifyPersonCmd = &cobra.Command{
 		}
 
 		if !exists {
-			fmt.Printf("Person %s does not exist in LDAP\n", uid)
-			return nil
+			// Drift is an expected outcome, not a usage error
+			cmd.SilenceUsage = true
+			return fmt.Errorf("person %s does not exist in LDAP", uid)
 		}
 
 		// Get person from LDAP
@@ -133,41 +167,346 @@ var verifyPersonCmd = &cobra.Command{
 
 		// Compare attributes
 		fmt.Printf("Verifying person: %s\n", uid)
+		match := verifyAttributeSet(entry, "objectClass", client.GetPersonAttributes(person)["objectClass"])
 
 		// Required attributes
-		verifyAttribute(entry, "cn", []string{person.CN})
-		verifyAttribute(entry, "sn", []string{person.GetSN()})
+		match = verifyAttribute(entry, "cn", []string{person.CN}) && match
+		match = verifyAttribute(entry, "sn", []string{person.GetSN()}) && match
 
 		// Optional attributes
 		if person.GivenName != "" {
-			verifyAttribute(entry, "givenName", []string{person.GivenName})
+			match = verifyAttribute(entry, "givenName", []string{person.GivenName}) && match
 		}
 		if person.Mail != "" {
-			verifyAttribute(entry, "mail", []string{person.Mail})
+			match = verifyAttribute(entry, "mail", []string{person.Mail}) && match
 		}
+		if len(person.SSHKeys) > 0 {
+			match = verifyAttributeSet(entry, "sshPublicKey", person.SSHKeys) && match
+		}
+		match = verifyExtraAttributes(entry, person.Attributes) && match
 
 		// POSIX attributes
 		if person.IsPosix() {
-			verifyAttribute(entry, "uidNumber", []string{fmt.Sprintf("%d", person.GetUIDNumber())})
-			verifyAttribute(entry, "gidNumber", []string{fmt.Sprintf("%d", person.GetGIDNumber())})
+			match = verifyAttribute(entry, "uidNumber", []string{fmt.Sprintf("%d", person.GetUIDNumber())}) && match
+			match = verifyAttribute(entry, "gidNumber", []string{fmt.Sprintf("%d", person.GetGIDNumber())}) && match
+			match = verifyAttribute(entry, "homeDirectory", []string{cfg.GetHomeDirectory(uid, person.HomeDirectory)}) && match
+			match = verifyAttribute(entry, "loginShell", []string{cfg.GetPersonLoginShell(person)}) && match
 		}
 
+		if !match {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("drift detected: person %s differs from the configuration", uid)
+		}
 		return nil
 	},
 }
 
-// verifyAttribute checks if an attribute in an LDAP entry matches the expected values
-func verifyAttribute(entry *ldapv3.Entry, attrName string, expectedValues []string) {
+// verifySvcAcctCmd represents the verify-svcacct command
+var verifySvcAcctCmd = &cobra.Command{
+	Use:   "verify-svcacct [uid]",
+	Short: "Verify a specific service account",
+	Long: `Verifies that a specific service account in LDAP matches the configuration.
+Exits with a non-zero status if it does not.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cfg == nil {
+			return fmt.Errorf("no configuration loaded")
+		}
+
+		uid := args[0]
+		svcacct, ok := cfg.LDAPEnforcer.SvcAcct[uid]
+		if !ok {
+			return fmt.Errorf("service account %s not found in configuration", uid)
+		}
+
+		// Create LDAP client
+		client, err := ldap.NewClient(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create LDAP client: %w", err)
+		}
+
+		// Ensure connection is closed at the end of the operation
+		defer func() {
+			if closeErr := client.Close(); closeErr != nil {
+				logging.DefaultLogger.Warn("Error closing LDAP connection: %v", closeErr)
+			}
+		}()
+
+		// Fill in allocated POSIX numbers and user private groups
+		if err := client.PrepareConfig(); err != nil {
+			return fmt.Errorf("failed to prepare configuration: %w", err)
+		}
+
+		// Check if service account exists
+		dn := client.SvcAcctToDN(uid)
+		exists, err := client.EntryExists(dn)
+		if err != nil {
+			return fmt.Errorf("failed to check if service account exists: %w", err)
+		}
+
+		if !exists {
+			// Drift is an expected outcome, not a usage error
+			cmd.SilenceUsage = true
+			return fmt.Errorf("service account %s does not exist in LDAP", uid)
+		}
+
+		// Get service account from LDAP
+		entry, err := client.GetEntity(dn, []string{"*"})
+		if err != nil {
+			return fmt.Errorf("failed to get service account from LDAP: %w", err)
+		}
+
+		// Compare attributes
+		fmt.Printf("Verifying service account: %s\n", uid)
+		match := verifyAttributeSet(entry, "objectClass", client.GetSvcAcctAttributes(svcacct)["objectClass"])
+
+		// Required attributes
+		match = verifyAttribute(entry, "cn", []string{svcacct.CN}) && match
+		match = verifyAttribute(entry, "sn", []string{uid}) && match
+		match = verifyAttribute(entry, "description", []string{svcacct.Description}) && match
+
+		// Optional attributes
+		if svcacct.Mail != "" {
+			match = verifyAttribute(entry, "mail", []string{svcacct.Mail}) && match
+		}
+		if len(svcacct.SSHKeys) > 0 {
+			match = verifyAttributeSet(entry, "sshPublicKey", svcacct.SSHKeys) && match
+		}
+		match = verifyExtraAttributes(entry, svcacct.Attributes) && match
+
+		// POSIX attributes
+		if svcacct.IsPosix() {
+			match = verifyAttribute(entry, "uidNumber", []string{fmt.Sprintf("%d", svcacct.GetUIDNumber())}) && match
+			match = verifyAttribute(entry, "gidNumber", []string{fmt.Sprintf("%d", svcacct.GetGIDNumber())}) && match
+			match = verifyAttribute(entry, "homeDirectory", []string{cfg.GetHomeDirectory(uid, svcacct.HomeDirectory)}) && match
+			match = verifyAttribute(entry, "loginShell", []string{cfg.GetSvcAcctLoginShell(svcacct)}) && match
+		}
+
+		if !match {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("drift detected: service account %s differs from the configuration", uid)
+		}
+		return nil
+	},
+}
+
+// verifyGroupCmd represents the verify-group command
+var verifyGroupCmd = &cobra.Command{
+	Use:   "verify-group [name]",
+	Short: "Verify a specific group",
+	Long: `Verifies that a specific group in LDAP matches the configuration,
+comparing its members (including members of nested groups) as an unordered set.
+Exits with a non-zero status if it does not match.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cfg == nil {
+			return fmt.Errorf("no configuration loaded")
+		}
+
+		groupname := args[0]
+
+		// Create LDAP client
+		client, err := ldap.NewClient(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create LDAP client: %w", err)
+		}
+
+		// Ensure connection is closed at the end of the operation
+		defer func() {
+			if closeErr := client.Close(); closeErr != nil {
+				logging.DefaultLogger.Warn("Error closing LDAP connection: %v", closeErr)
+			}
+		}()
+
+		// Fill in allocated POSIX numbers and user private groups
+		if err := client.PrepareConfig(); err != nil {
+			return fmt.Errorf("failed to prepare configuration: %w", err)
+		}
+
+		// User private groups only exist once the configuration is prepared
+		group, ok := cfg.LDAPEnforcer.Group[groupname]
+		if !ok {
+			return fmt.Errorf("group %s not found in configuration", groupname)
+		}
+
+		// Resolve the expected members, including members of nested groups
+		members, err := model.GetGroupMembers(
+			groupname,
+			cfg.LDAPEnforcer.Group,
+			cfg.LDAPEnforcer.Person,
+			cfg.LDAPEnforcer.SvcAcct,
+			cfg.LDAPEnforcer.EnforcedPeopleOU,
+			cfg.LDAPEnforcer.EnforcedSvcAcctOU,
+			cfg.LDAPEnforcer.EnforcedGroupOU,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to resolve members of group %s: %w", groupname, err)
+		}
+		var memberUIDs []string
+		for _, member := range members {
+			if member.IsPosix {
+				memberUIDs = append(memberUIDs, member.UID)
+			}
+		}
+
+		// member lists nested groups according to the nesting mode
+		listed, err := model.ResolveGroupMembers(
+			groupname,
+			cfg.GetGroupNesting(group),
+			cfg.LDAPEnforcer.Group,
+			cfg.LDAPEnforcer.Person,
+			cfg.LDAPEnforcer.SvcAcct,
+			cfg.LDAPEnforcer.EnforcedPeopleOU,
+			cfg.LDAPEnforcer.EnforcedSvcAcctOU,
+			cfg.LDAPEnforcer.EnforcedGroupOU,
+			cfg.GetPrivateGroupOU(),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to resolve members of group %s: %w", groupname, err)
+		}
+		var memberDNs []string
+		for _, member := range listed {
+			memberDNs = append(memberDNs, member.DN)
+		}
+
+		// Check if group exists
+		dn := client.GroupToDN(groupname)
+		exists, err := client.EntryExists(dn)
+		if err != nil {
+			return fmt.Errorf("failed to check if group exists: %w", err)
+		}
+
+		if !exists {
+			// Drift is an expected outcome, not a usage error
+			cmd.SilenceUsage = true
+			return fmt.Errorf("group %s does not exist in LDAP", groupname)
+		}
+
+		// Get group from LDAP
+		entry, err := client.GetEntity(dn, []string{"*"})
+		if err != nil {
+			return fmt.Errorf("failed to get group from LDAP: %w", err)
+		}
+
+		// Compare attributes
+		fmt.Printf("Verifying group: %s\n", groupname)
+
+		objectClasses := []string{"top", "groupOfNames"}
+		if group.IsPosix() {
+			objectClasses = append(objectClasses, "posixGroup")
+		}
+		objectClasses = append(objectClasses, group.ExtraObjectClasses...)
+		match := verifyAttributeSet(entry, "objectClass", objectClasses)
+		if group.AllowEmpty {
+			// The placeholder that keeps an empty group valid is not a real member
+			hideAttributeValue(entry, "member", cfg.GetEmptyGroupPlaceholder())
+		}
+		match = verifyAttributeSet(entry, "member", memberDNs) && match
+		match = verifyExtraAttributes(entry, group.Attributes) && match
+
+		// POSIX attributes
+		if group.IsPosix() {
+			match = verifyAttribute(entry, "gidNumber", []string{fmt.Sprintf("%d", group.PosixGidNumber)}) && match
+			match = verifyAttributeSet(entry, "memberUid", memberUIDs) && match
+		} else {
+			match = verifyAttribute(entry, "gidNumber", nil) && match
+			match = verifyAttribute(entry, "memberUid", nil) && match
+		}
+
+		if !match {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("drift detected: group %s differs from the configuration", groupname)
+		}
+		return nil
+	},
+}
+
+// verifyExtraAttributes checks the attributes from an entity's attributes table,
+// returning whether they all match
+func verifyExtraAttributes(entry *ldapv3.Entry, attributes map[string][]string) bool {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	match := true
+	for _, name := range names {
+		match = verifyAttributeSet(entry, name, attributes[name]) && match
+	}
+	return match
+}
+
+// verifyAttributeSet checks if an attribute in an LDAP entry holds the expected values in any order,
+// comparing values case-insensitively as LDAP does for DNs and object classes, and returns whether it does
+func verifyAttributeSet(entry *ldapv3.Entry, attrName string, expectedValues []string) bool {
+	attribute := entry.GetAttributeValues(attrName)
+	missing, unexpected := diffValueSets(attribute, expectedValues)
+
+	if len(missing) == 0 && len(unexpected) == 0 {
+		fmt.Printf("✓ %s: %d values match\n", attrName, len(attribute))
+		return true
+	}
+
+	fmt.Printf("✗ %s: %d values, expected %d\n", attrName, len(attribute), len(expectedValues))
+	for _, value := range missing {
+		fmt.Printf("    missing: %s\n", value)
+	}
+	for _, value := range unexpected {
+		fmt.Printf("    unexpected: %s\n", value)
+	}
+	return false
+}
+
+// hideAttributeValue removes a value from an entry's attribute before it is verified, ignoring case
+func hideAttributeValue(entry *ldapv3.Entry, attrName, value string) {
+	for _, attribute := range entry.Attributes {
+		if !strings.EqualFold(attribute.Name, attrName) {
+			continue
+		}
+		var kept []string
+		for _, v := range attribute.Values {
+			if !strings.EqualFold(v, value) {
+				kept = append(kept, v)
+			}
+		}
+		attribute.Values = kept
+	}
+}
+
+// diffValueSets returns the expected values that are missing from actual
+// and the actual values that are not expected, ignoring order and case
+func diffValueSets(actual, expected []string) (missing, unexpected []string) {
+	actualSet := make(map[string]bool, len(actual))
+	for _, value := range actual {
+		actualSet[strings.ToLower(value)] = true
+	}
+	expectedSet := make(map[string]bool, len(expected))
+	for _, value := range expected {
+		expectedSet[strings.ToLower(value)] = true
+		if !actualSet[strings.ToLower(value)] {
+			missing = append(missing, value)
+		}
+	}
+	for _, value := range actual {
+		if !expectedSet[strings.ToLower(value)] {
+			unexpected = append(unexpected, value)
+		}
+	}
+	return missing, unexpected
+}
+
+// verifyAttribute checks if an attribute in an LDAP entry matches the expected values, and returns whether it does
+func verifyAttribute(entry *ldapv3.Entry, attrName string, expectedValues []string) bool {
 	attribute := entry.GetAttributeValues(attrName)
 
 	// Check if attribute exists
 	if len(attribute) == 0 {
 		if len(expectedValues) == 0 {
 			fmt.Printf("✓ %s: not set (as expected)\n", attrName)
-		} else {
-			fmt.Printf("✗ %s: not set (expected %v)\n", attrName, expectedValues)
+			return true
 		}
-		return
+		fmt.Printf("✗ %s: not set (expected %v)\n", attrName, expectedValues)
+		return false
 	}
 
 	// Check if values match
@@ -186,12 +525,18 @@ func verifyAttribute(entry *ldapv3.Entry, attrName string, expectedValues []stri
 	} else {
 		fmt.Printf("✗ %s: %v (expected %v)\n", attrName, attribute, expectedValues)
 	}
+	return match
 }
 
 func init() {
 	// Add commands to the root command
 	RootCmd.AddCommand(verifyCmd)
 
+	// Add flags to the verify command
+	verifyCmd.Flags().String("format", "text", "Output format: text or json")
+
 	// Add subcommands to the verify command
 	verifyCmd.AddCommand(verifyPersonCmd)
+	verifyCmd.AddCommand(verifySvcAcctCmd)
+	verifyCmd.AddCommand(verifyGroupCmd)
 }
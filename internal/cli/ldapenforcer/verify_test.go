@@ -0,0 +1,62 @@
+package ldapenforcer
+
+import (
+	"reflect"
+	"testing"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+func TestDiffValueSets(t *testing.T) {
+	actual := []string{
+		"uid=bob,ou=people,dc=example,dc=com",
+		"UID=Alice,ou=people,dc=example,dc=com",
+		"uid=mallory,ou=people,dc=example,dc=com",
+	}
+	expected := []string{
+		"uid=alice,ou=people,dc=example,dc=com",
+		"uid=bob,ou=people,dc=example,dc=com",
+		"uid=carol,ou=people,dc=example,dc=com",
+	}
+
+	missing, unexpected := diffValueSets(actual, expected)
+
+	if want := []string{"uid=carol,ou=people,dc=example,dc=com"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("Expected missing %v, got %v", want, missing)
+	}
+	if want := []string{"uid=mallory,ou=people,dc=example,dc=com"}; !reflect.DeepEqual(unexpected, want) {
+		t.Errorf("Expected unexpected %v, got %v", want, unexpected)
+	}
+
+	// Order does not matter
+	missing, unexpected = diffValueSets([]string{"b", "a"}, []string{"a", "b"})
+	if len(missing) != 0 || len(unexpected) != 0 {
+		t.Errorf("Expected no differences, got missing %v and unexpected %v", missing, unexpected)
+	}
+}
+
+func TestVerifyAttributeReportsMismatch(t *testing.T) {
+	entry := ldapv3.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+		"objectClass": {"top", "inetOrgPerson"},
+		"mail":        {"alice@example.com"},
+	})
+
+	if !verifyAttribute(entry, "mail", []string{"alice@example.com"}) {
+		t.Error("Expected a matching mail to verify")
+	}
+	if verifyAttribute(entry, "mail", []string{"alice@example.org"}) {
+		t.Error("Expected a different mail not to verify")
+	}
+	if verifyAttribute(entry, "givenName", []string{"Alice"}) {
+		t.Error("Expected a missing givenName not to verify")
+	}
+	if !verifyAttributeSet(entry, "objectClass", []string{"inetOrgPerson", "top"}) {
+		t.Error("Expected the object classes to verify in any order")
+	}
+	if verifyAttributeSet(entry, "objectClass", []string{"top", "inetOrgPerson", "posixAccount"}) {
+		t.Error("Expected a missing object class not to verify")
+	}
+	if verifyExtraAttributes(entry, map[string][]string{"title": {"Engineer"}}) {
+		t.Error("Expected a missing extra attribute not to verify")
+	}
+}
@@ -2,20 +2,37 @@ package config
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/go-ldap/ldap/v3"
 	"github.com/mrled/ldapenforcer/internal/logging"
 	"github.com/mrled/ldapenforcer/internal/model"
 	"github.com/spf13/pflag"
 )
 
+// Defaults for POSIX account attributes
+const (
+	DefaultLoginShell        = "/bin/bash"
+	DefaultSvcAcctLoginShell = "/usr/sbin/nologin"
+	DefaultHomeDirectoryBase = "/home"
+)
+
+// DefaultEmptyGroupPlaceholder is the member of groups with allow_empty that have no real members.
+// It does not need to exist.
+const DefaultEmptyGroupPlaceholder = "cn=nobody"
+
 // Config represents the application configuration
 type Config struct {
 	// LDAPEnforcer configuration
@@ -69,9 +86,68 @@ type LDAPEnforcerConfig struct {
 	// Interval for polling LDAP server for changes (when poll is enabled via command line)
 	PollLDAPInterval string `toml:"poll_ldap_interval"`
 
+	// Maximum number of entries a single sync may delete (0 for no limit)
+	MaxDeletions int `toml:"max_deletions"`
+
+	// Maximum percentage of the entries in the enforced OUs a single sync may delete (0 for no limit)
+	MaxDeletionPercent int `toml:"max_deletion_percent"`
+
+	// Allow a sync to exceed the deletion limits (command line only)
+	AllowMassDelete bool `toml:"-"`
+
+	// Allow applying a plan made from a different configuration (command line only)
+	AllowConfigChange bool `toml:"-"`
+
+	// Full OU to move removed people and service accounts into instead of deleting them (optional)
+	QuarantineOU string `toml:"quarantine_ou"`
+
+	// How long quarantined entries are kept before they are purged, e.g. "720h" (empty keeps them forever)
+	QuarantineRetention string `toml:"quarantine_retention"`
+
+	// Keep syncing after an entity fails, skipping only the entities that depend on it
+	ContinueOnError bool `toml:"continue_on_error"`
+
+	// Login shell for POSIX people that do not set login_shell
+	DefaultLoginShell string `toml:"default_login_shell"`
+
+	// Login shell for POSIX service accounts that do not set login_shell
+	SvcAcctLoginShell string `toml:"svcacct_login_shell"`
+
+	// Directory that POSIX home directories are created under, as <base>/<uid>
+	HomeDirectoryBase string `toml:"home_directory_base"`
+
+	// How groups represent nested groups in member: "flatten" (default), "reference", or "both"
+	GroupNesting string `toml:"group_nesting"`
+
+	// Member DN that keeps groups with allow_empty valid when they have no members
+	EmptyGroupPlaceholder string `toml:"empty_group_placeholder"`
+
+	// Create a POSIX group for each POSIX person, named after the person and containing only them
+	UserPrivateGroup bool `toml:"user_private_group"`
+
+	// Full OU for user private groups (optional, defaults to enforced_group_ou)
+	PrivateGroupOU string `toml:"private_group_ou"`
+
+	// Make people and service accounts members of their primary group
+	PrimaryGroupMember bool `toml:"primary_group_member"`
+
+	// Range of UID numbers allocated to people and service accounts with auto_posix, as [first, last]
+	UIDRange []int `toml:"uid_range"`
+
+	// Range of GID numbers allocated to groups with auto_posix, as [first, last]
+	GIDRange []int `toml:"gid_range"`
+
+	// JSON file that records allocated UID and GID numbers and the attribute names and account locks syncs have written,
+	// relative to the main config file (optional)
+	// Without it, allocated numbers are read back from the entries in LDAP
+	PosixStateFile string `toml:"posix_state_file"`
+
 	// List of config files to include
 	Includes []string `toml:"includes"`
 
+	// Templates for people and service accounts - map of template name to template
+	Template map[string]*model.Template `toml:"template"`
+
 	// Person configurations - map of uid to person config
 	Person map[string]*model.Person `toml:"person"`
 
@@ -124,6 +200,9 @@ func LoadConfig(configFile string) (*Config, error) {
 	config.LDAPEnforcer.LDAPLogLevel = "INFO"
 	config.LDAPEnforcer.PollConfigInterval = "10s"
 	config.LDAPEnforcer.PollLDAPInterval = "24h"
+	config.LDAPEnforcer.DefaultLoginShell = DefaultLoginShell
+	config.LDAPEnforcer.SvcAcctLoginShell = DefaultSvcAcctLoginShell
+	config.LDAPEnforcer.HomeDirectoryBase = DefaultHomeDirectoryBase
 
 	// Load the main config file (second lowest precedence)
 	err = config.loadConfigFile(configFile)
@@ -131,9 +210,44 @@ func LoadConfig(configFile string) (*Config, error) {
 		return nil, err
 	}
 
+	// Templates can be defined in any file, so they are applied once everything is loaded
+	err = config.applyTemplates()
+	if err != nil {
+		return nil, err
+	}
+
 	return config, nil
 }
 
+// applyTemplates fills in the fields of each person and service account from its template
+func (c *Config) applyTemplates() error {
+	templates, err := model.ResolveTemplates(c.LDAPEnforcer.Template)
+	if err != nil {
+		return err
+	}
+	for uid, person := range c.LDAPEnforcer.Person {
+		if person.Template == "" {
+			continue
+		}
+		template, ok := templates[person.Template]
+		if !ok {
+			return fmt.Errorf("person %s: unknown template %s", uid, person.Template)
+		}
+		template.ApplyToPerson(person)
+	}
+	for uid, svcacct := range c.LDAPEnforcer.SvcAcct {
+		if svcacct.Template == "" {
+			continue
+		}
+		template, ok := templates[svcacct.Template]
+		if !ok {
+			return fmt.Errorf("service account %s: unknown template %s", uid, svcacct.Template)
+		}
+		template.ApplyToSvcAcct(svcacct)
+	}
+	return nil
+}
+
 // loadConfigFile loads a config file and processes includes
 func (c *Config) loadConfigFile(configFile string) error {
 	// Resolve the absolute path
@@ -162,6 +276,16 @@ func (c *Config) loadConfigFile(configFile string) error {
 	// Get the directory for this config file
 	configDir := filepath.Dir(absPath)
 
+	// Read password and SSH key files relative to the config file that references them
+	err = config.loadPasswordFiles(configDir)
+	if err != nil {
+		return fmt.Errorf("failed to load password files for %s: %w", absPath, err)
+	}
+	err = config.loadSSHKeysFiles(configDir)
+	if err != nil {
+		return fmt.Errorf("failed to load SSH key files for %s: %w", absPath, err)
+	}
+
 	// First merge the current config file into our config
 	c.merge(&config)
 
@@ -184,6 +308,82 @@ func (c *Config) loadConfigFile(configFile string) error {
 	return nil
 }
 
+// loadPasswordFiles sets the password hash of each person and service account
+// that has a password_file from the contents of that file
+func (c *Config) loadPasswordFiles(dir string) error {
+	for uid, person := range c.LDAPEnforcer.Person {
+		hash, err := readPasswordHashFile(person.PasswordHash, person.PasswordFile, dir)
+		if err != nil {
+			return fmt.Errorf("person %s: %w", uid, err)
+		}
+		person.PasswordHash = hash
+	}
+	for uid, svcacct := range c.LDAPEnforcer.SvcAcct {
+		hash, err := readPasswordHashFile(svcacct.PasswordHash, svcacct.PasswordFile, dir)
+		if err != nil {
+			return fmt.Errorf("service account %s: %w", uid, err)
+		}
+		svcacct.PasswordHash = hash
+	}
+	return nil
+}
+
+// readPasswordHashFile returns the password hash from passwordFile, or hash if no file is set
+func readPasswordHashFile(hash, passwordFile, dir string) (string, error) {
+	if passwordFile == "" {
+		return hash, nil
+	}
+	if hash != "" {
+		return "", fmt.Errorf("password_hash and password_file cannot both be set")
+	}
+
+	path := passwordFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password file %s: %w", path, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// loadSSHKeysFiles appends the keys from the ssh_keys_file of each person and service account to its ssh_keys
+func (c *Config) loadSSHKeysFiles(dir string) error {
+	for uid, person := range c.LDAPEnforcer.Person {
+		keys, err := readSSHKeysFile(person.SSHKeysFile, dir)
+		if err != nil {
+			return fmt.Errorf("person %s: %w", uid, err)
+		}
+		person.SSHKeys = append(person.SSHKeys, keys...)
+	}
+	for uid, svcacct := range c.LDAPEnforcer.SvcAcct {
+		keys, err := readSSHKeysFile(svcacct.SSHKeysFile, dir)
+		if err != nil {
+			return fmt.Errorf("service account %s: %w", uid, err)
+		}
+		svcacct.SSHKeys = append(svcacct.SSHKeys, keys...)
+	}
+	return nil
+}
+
+// readSSHKeysFile returns the keys in an SSH keys file, or nothing if no file is set
+func readSSHKeysFile(keysFile, dir string) ([]string, error) {
+	if keysFile == "" {
+		return nil, nil
+	}
+
+	path := keysFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH keys file %s: %w", path, err)
+	}
+	return model.ParseSSHKeysFile(string(data)), nil
+}
+
 // merge merges another config into this one
 func (c *Config) merge(other *Config) {
 	// Only merge non-empty values
@@ -231,10 +431,69 @@ func (c *Config) merge(other *Config) {
 	if other.LDAPEnforcer.PollLDAPInterval != "" {
 		c.LDAPEnforcer.PollLDAPInterval = other.LDAPEnforcer.PollLDAPInterval
 	}
+	if other.LDAPEnforcer.MaxDeletions != 0 {
+		c.LDAPEnforcer.MaxDeletions = other.LDAPEnforcer.MaxDeletions
+	}
+	if other.LDAPEnforcer.MaxDeletionPercent != 0 {
+		c.LDAPEnforcer.MaxDeletionPercent = other.LDAPEnforcer.MaxDeletionPercent
+	}
+	if other.LDAPEnforcer.ContinueOnError {
+		c.LDAPEnforcer.ContinueOnError = true
+	}
+	if other.LDAPEnforcer.QuarantineOU != "" {
+		c.LDAPEnforcer.QuarantineOU = other.LDAPEnforcer.QuarantineOU
+	}
+	if other.LDAPEnforcer.QuarantineRetention != "" {
+		c.LDAPEnforcer.QuarantineRetention = other.LDAPEnforcer.QuarantineRetention
+	}
+	if other.LDAPEnforcer.DefaultLoginShell != "" {
+		c.LDAPEnforcer.DefaultLoginShell = other.LDAPEnforcer.DefaultLoginShell
+	}
+	if other.LDAPEnforcer.SvcAcctLoginShell != "" {
+		c.LDAPEnforcer.SvcAcctLoginShell = other.LDAPEnforcer.SvcAcctLoginShell
+	}
+	if other.LDAPEnforcer.HomeDirectoryBase != "" {
+		c.LDAPEnforcer.HomeDirectoryBase = other.LDAPEnforcer.HomeDirectoryBase
+	}
+	if other.LDAPEnforcer.GroupNesting != "" {
+		c.LDAPEnforcer.GroupNesting = other.LDAPEnforcer.GroupNesting
+	}
+	if other.LDAPEnforcer.EmptyGroupPlaceholder != "" {
+		c.LDAPEnforcer.EmptyGroupPlaceholder = other.LDAPEnforcer.EmptyGroupPlaceholder
+	}
+	// For boolean flags like UserPrivateGroup, only merge if true
+	if other.LDAPEnforcer.UserPrivateGroup {
+		c.LDAPEnforcer.UserPrivateGroup = true
+	}
+	if other.LDAPEnforcer.PrivateGroupOU != "" {
+		c.LDAPEnforcer.PrivateGroupOU = other.LDAPEnforcer.PrivateGroupOU
+	}
+	if other.LDAPEnforcer.PrimaryGroupMember {
+		c.LDAPEnforcer.PrimaryGroupMember = true
+	}
+	if len(other.LDAPEnforcer.UIDRange) > 0 {
+		c.LDAPEnforcer.UIDRange = other.LDAPEnforcer.UIDRange
+	}
+	if len(other.LDAPEnforcer.GIDRange) > 0 {
+		c.LDAPEnforcer.GIDRange = other.LDAPEnforcer.GIDRange
+	}
+	if other.LDAPEnforcer.PosixStateFile != "" {
+		c.LDAPEnforcer.PosixStateFile = other.LDAPEnforcer.PosixStateFile
+	}
 
 	// Make sure we also append any includes
 	c.LDAPEnforcer.Includes = append(c.LDAPEnforcer.Includes, other.LDAPEnforcer.Includes...)
 
+	// Merge templates
+	if other.LDAPEnforcer.Template != nil {
+		if c.LDAPEnforcer.Template == nil {
+			c.LDAPEnforcer.Template = make(map[string]*model.Template)
+		}
+		for name, template := range other.LDAPEnforcer.Template {
+			c.LDAPEnforcer.Template[name] = template
+		}
+	}
+
 	// Merge people
 	if other.LDAPEnforcer.Person != nil {
 		if c.LDAPEnforcer.Person == nil {
@@ -499,6 +758,12 @@ func (c *Config) MergeWithEnv() {
 			c.LDAPEnforcer.PasswordCommandViaShell = true
 		}
 	}
+	if val := os.Getenv("LDAPENFORCER_CONTINUE_ON_ERROR"); val != "" {
+		boolValue, err := strconv.ParseBool(val)
+		if err == nil && boolValue {
+			c.LDAPEnforcer.ContinueOnError = true
+		}
+	}
 	if val := os.Getenv("LDAPENFORCER_CA_CERT_FILE"); val != "" {
 		c.LDAPEnforcer.CACertFile = val
 	}
@@ -521,6 +786,61 @@ func (c *Config) MergeWithEnv() {
 	if val := os.Getenv("LDAPENFORCER_ENFORCED_GROUP_OU"); val != "" {
 		c.LDAPEnforcer.EnforcedGroupOU = val
 	}
+	if val := os.Getenv("LDAPENFORCER_QUARANTINE_OU"); val != "" {
+		c.LDAPEnforcer.QuarantineOU = val
+	}
+	if val := os.Getenv("LDAPENFORCER_QUARANTINE_RETENTION"); val != "" {
+		c.LDAPEnforcer.QuarantineRetention = val
+	}
+
+	// POSIX account defaults
+	if val := os.Getenv("LDAPENFORCER_DEFAULT_LOGIN_SHELL"); val != "" {
+		c.LDAPEnforcer.DefaultLoginShell = val
+	}
+	if val := os.Getenv("LDAPENFORCER_SVCACCT_LOGIN_SHELL"); val != "" {
+		c.LDAPEnforcer.SvcAcctLoginShell = val
+	}
+	if val := os.Getenv("LDAPENFORCER_HOME_DIRECTORY_BASE"); val != "" {
+		c.LDAPEnforcer.HomeDirectoryBase = val
+	}
+
+	// Group settings
+	if val := os.Getenv("LDAPENFORCER_GROUP_NESTING"); val != "" {
+		c.LDAPEnforcer.GroupNesting = val
+	}
+	if val := os.Getenv("LDAPENFORCER_EMPTY_GROUP_PLACEHOLDER"); val != "" {
+		c.LDAPEnforcer.EmptyGroupPlaceholder = val
+	}
+	if val := os.Getenv("LDAPENFORCER_USER_PRIVATE_GROUP"); val != "" {
+		boolValue, err := strconv.ParseBool(val)
+		if err == nil && boolValue {
+			c.LDAPEnforcer.UserPrivateGroup = true
+		}
+	}
+	if val := os.Getenv("LDAPENFORCER_PRIVATE_GROUP_OU"); val != "" {
+		c.LDAPEnforcer.PrivateGroupOU = val
+	}
+	if val := os.Getenv("LDAPENFORCER_PRIMARY_GROUP_MEMBER"); val != "" {
+		boolValue, err := strconv.ParseBool(val)
+		if err == nil && boolValue {
+			c.LDAPEnforcer.PrimaryGroupMember = true
+		}
+	}
+
+	// POSIX number allocation - ranges as comma-separated first and last numbers
+	if val := os.Getenv("LDAPENFORCER_UID_RANGE"); val != "" {
+		if idRange, err := parseIntList(val); err == nil {
+			c.LDAPEnforcer.UIDRange = idRange
+		}
+	}
+	if val := os.Getenv("LDAPENFORCER_GID_RANGE"); val != "" {
+		if idRange, err := parseIntList(val); err == nil {
+			c.LDAPEnforcer.GIDRange = idRange
+		}
+	}
+	if val := os.Getenv("LDAPENFORCER_POSIX_STATE_FILE"); val != "" {
+		c.LDAPEnforcer.PosixStateFile = val
+	}
 
 	// Polling configuration
 	if val := os.Getenv("LDAPENFORCER_POLL_CONFIG_INTERVAL"); val != "" {
@@ -530,6 +850,18 @@ func (c *Config) MergeWithEnv() {
 		c.LDAPEnforcer.PollLDAPInterval = val
 	}
 
+	// Deletion safety limits
+	if val := os.Getenv("LDAPENFORCER_MAX_DELETIONS"); val != "" {
+		if intValue, err := strconv.Atoi(val); err == nil {
+			c.LDAPEnforcer.MaxDeletions = intValue
+		}
+	}
+	if val := os.Getenv("LDAPENFORCER_MAX_DELETION_PERCENT"); val != "" {
+		if intValue, err := strconv.Atoi(val); err == nil {
+			c.LDAPEnforcer.MaxDeletionPercent = intValue
+		}
+	}
+
 	// Includes - process as comma-separated list
 	if val := os.Getenv("LDAPENFORCER_INCLUDES"); val != "" {
 		includes := strings.Split(val, ",")
@@ -555,8 +887,26 @@ func AddFlags(flags *pflag.FlagSet) {
 	flags.String("enforced-people-ou", "", "Full OU for enforced people")
 	flags.String("enforced-svcacct-ou", "", "Full OU for enforced service accounts")
 	flags.String("enforced-group-ou", "", "Full OU for enforced groups")
+	flags.String("quarantine-ou", "", "Full OU to move removed people and service accounts into instead of deleting them")
+	flags.String("quarantine-retention", "", "How long quarantined entries are kept before they are purged, e.g. \"720h\" (empty keeps them forever)")
+	flags.String("default-login-shell", "", "Login shell for POSIX people that do not set login_shell (default \"/bin/bash\")")
+	flags.String("svcacct-login-shell", "", "Login shell for POSIX service accounts that do not set login_shell (default \"/usr/sbin/nologin\")")
+	flags.String("home-directory-base", "", "Directory that POSIX home directories are created under (default \"/home\")")
+	flags.String("group-nesting", "", "How groups represent nested groups in member: \"flatten\" (default), \"reference\", or \"both\"")
+	flags.String("empty-group-placeholder", "", "Member DN that keeps groups with allow_empty valid when they have no members (default \"cn=nobody\")")
+	flags.Bool("user-private-group", false, "Create a POSIX group for each POSIX person, named after the person and containing only them")
+	flags.String("private-group-ou", "", "Full OU for user private groups (default: the enforced group OU)")
+	flags.Bool("primary-group-member", false, "Make people and service accounts members of their primary group")
+	flags.IntSlice("uid-range", nil, "Range of UID numbers allocated with auto_posix, as first,last")
+	flags.IntSlice("gid-range", nil, "Range of GID numbers allocated to groups with auto_posix, as first,last")
+	flags.String("posix-state-file", "", "JSON file that records allocated UID and GID numbers, written attribute names, and account locks")
 	flags.String("poll-config-interval", "10s", "Interval for --poll mode to check if the config file has changed and sync if so (recommended: \"10s\")")
 	flags.String("poll-ldap-interval", "24h", "Interval for --poll mode to compare the config file to the LDAP server and sync if different (recommended: \"24h\")")
+	flags.Int("max-deletions", 0, "Maximum number of entries a single sync may delete (0 for no limit)")
+	flags.Int("max-deletion-percent", 0, "Maximum percentage of the entries in the enforced OUs a single sync may delete (0 for no limit)")
+	flags.Bool("allow-mass-delete", false, "Allow a sync to exceed the max-deletions and max-deletion-percent limits")
+	flags.Bool("allow-config-change", false, "Allow applying a plan that was made from a different configuration")
+	flags.Bool("continue-on-error", false, "Keep syncing after an entity fails, skipping only the entities that depend on it")
 }
 
 // MergeWithFlags merges command line flag values into the config
@@ -597,12 +947,66 @@ func (c *Config) MergeWithFlags(flags *pflag.FlagSet) {
 	if enforcedGroupOU, _ := flags.GetString("enforced-group-ou"); enforcedGroupOU != "" {
 		c.LDAPEnforcer.EnforcedGroupOU = enforcedGroupOU
 	}
+	if quarantineOU, _ := flags.GetString("quarantine-ou"); quarantineOU != "" {
+		c.LDAPEnforcer.QuarantineOU = quarantineOU
+	}
+	if quarantineRetention, _ := flags.GetString("quarantine-retention"); quarantineRetention != "" {
+		c.LDAPEnforcer.QuarantineRetention = quarantineRetention
+	}
+	if defaultLoginShell, _ := flags.GetString("default-login-shell"); defaultLoginShell != "" {
+		c.LDAPEnforcer.DefaultLoginShell = defaultLoginShell
+	}
+	if svcacctLoginShell, _ := flags.GetString("svcacct-login-shell"); svcacctLoginShell != "" {
+		c.LDAPEnforcer.SvcAcctLoginShell = svcacctLoginShell
+	}
+	if homeDirectoryBase, _ := flags.GetString("home-directory-base"); homeDirectoryBase != "" {
+		c.LDAPEnforcer.HomeDirectoryBase = homeDirectoryBase
+	}
+	if groupNesting, _ := flags.GetString("group-nesting"); groupNesting != "" {
+		c.LDAPEnforcer.GroupNesting = groupNesting
+	}
+	if emptyGroupPlaceholder, _ := flags.GetString("empty-group-placeholder"); emptyGroupPlaceholder != "" {
+		c.LDAPEnforcer.EmptyGroupPlaceholder = emptyGroupPlaceholder
+	}
+	if userPrivateGroup, _ := flags.GetBool("user-private-group"); userPrivateGroup {
+		c.LDAPEnforcer.UserPrivateGroup = true
+	}
+	if privateGroupOU, _ := flags.GetString("private-group-ou"); privateGroupOU != "" {
+		c.LDAPEnforcer.PrivateGroupOU = privateGroupOU
+	}
+	if primaryGroupMember, _ := flags.GetBool("primary-group-member"); primaryGroupMember {
+		c.LDAPEnforcer.PrimaryGroupMember = true
+	}
+	if uidRange, _ := flags.GetIntSlice("uid-range"); len(uidRange) > 0 {
+		c.LDAPEnforcer.UIDRange = uidRange
+	}
+	if gidRange, _ := flags.GetIntSlice("gid-range"); len(gidRange) > 0 {
+		c.LDAPEnforcer.GIDRange = gidRange
+	}
+	if posixStateFile, _ := flags.GetString("posix-state-file"); posixStateFile != "" {
+		c.LDAPEnforcer.PosixStateFile = posixStateFile
+	}
 	if pollConfigInterval, _ := flags.GetString("poll-config-interval"); pollConfigInterval != "" {
 		c.LDAPEnforcer.PollConfigInterval = pollConfigInterval
 	}
 	if pollLDAPInterval, _ := flags.GetString("poll-ldap-interval"); pollLDAPInterval != "" {
 		c.LDAPEnforcer.PollLDAPInterval = pollLDAPInterval
 	}
+	if maxDeletions, _ := flags.GetInt("max-deletions"); maxDeletions != 0 {
+		c.LDAPEnforcer.MaxDeletions = maxDeletions
+	}
+	if maxDeletionPercent, _ := flags.GetInt("max-deletion-percent"); maxDeletionPercent != 0 {
+		c.LDAPEnforcer.MaxDeletionPercent = maxDeletionPercent
+	}
+	if allowMassDelete, _ := flags.GetBool("allow-mass-delete"); allowMassDelete {
+		c.LDAPEnforcer.AllowMassDelete = true
+	}
+	if allowConfigChange, _ := flags.GetBool("allow-config-change"); allowConfigChange {
+		c.LDAPEnforcer.AllowConfigChange = true
+	}
+	if continueOnError, _ := flags.GetBool("continue-on-error"); continueOnError {
+		c.LDAPEnforcer.ContinueOnError = true
+	}
 }
 
 // Validate checks if the configuration is valid
@@ -631,9 +1035,505 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("enforced group OU is required")
 	}
 
+	if c.LDAPEnforcer.MaxDeletions < 0 {
+		return fmt.Errorf("max_deletions must not be negative")
+	}
+	if c.LDAPEnforcer.MaxDeletionPercent < 0 || c.LDAPEnforcer.MaxDeletionPercent > 100 {
+		return fmt.Errorf("max_deletion_percent must be between 0 and 100")
+	}
+
+	if c.LDAPEnforcer.QuarantineOU != "" {
+		// Quarantined entries would otherwise look like unmanaged entries in an enforced OU
+		if c.withinEnforcedOU(c.LDAPEnforcer.QuarantineOU) {
+			return fmt.Errorf("quarantine OU must not be inside an enforced OU: %s", c.LDAPEnforcer.QuarantineOU)
+		}
+	}
+	if _, err := c.GetQuarantineRetention(); err != nil {
+		return err
+	}
+
+	for name, value := range map[string]string{
+		"default_login_shell": c.LDAPEnforcer.DefaultLoginShell,
+		"svcacct_login_shell": c.LDAPEnforcer.SvcAcctLoginShell,
+		"home_directory_base": c.LDAPEnforcer.HomeDirectoryBase,
+	} {
+		if value != "" && !strings.HasPrefix(value, "/") {
+			return fmt.Errorf("%s must be an absolute path: %s", name, value)
+		}
+	}
+
+	for uid, person := range c.LDAPEnforcer.Person {
+		if err := validatePosixPaths(person.LoginShell, person.HomeDirectory); err != nil {
+			return fmt.Errorf("person %s: %w", uid, err)
+		}
+		if err := model.ValidateExtraAttributes(person.Attributes, person.ExtraObjectClasses); err != nil {
+			return fmt.Errorf("person %s: %w", uid, err)
+		}
+		if person.Expires != "" {
+			if _, err := model.ParseExpires(person.Expires); err != nil {
+				return fmt.Errorf("person %s: %w", uid, err)
+			}
+		}
+		if person.PasswordHash != "" {
+			if err := model.ValidatePasswordHash(person.PasswordHash); err != nil {
+				return fmt.Errorf("person %s: %w", uid, err)
+			}
+		}
+		for i, key := range person.SSHKeys {
+			if err := model.ValidateSSHKey(key); err != nil {
+				return fmt.Errorf("person %s: SSH key %d: %w", uid, i+1, err)
+			}
+		}
+		for _, groupname := range person.Groups {
+			if _, ok := c.LDAPEnforcer.Group[groupname]; !ok {
+				return fmt.Errorf("person %s: unknown group %s", uid, groupname)
+			}
+		}
+		if err := c.validatePrimaryGroup(person.PrimaryGroup, person.Posix); err != nil {
+			return fmt.Errorf("person %s: %w", uid, err)
+		}
+	}
+	for uid, svcacct := range c.LDAPEnforcer.SvcAcct {
+		if err := validatePosixPaths(svcacct.LoginShell, svcacct.HomeDirectory); err != nil {
+			return fmt.Errorf("service account %s: %w", uid, err)
+		}
+		if err := model.ValidateExtraAttributes(svcacct.Attributes, svcacct.ExtraObjectClasses); err != nil {
+			return fmt.Errorf("service account %s: %w", uid, err)
+		}
+		if svcacct.Expires != "" {
+			if _, err := model.ParseExpires(svcacct.Expires); err != nil {
+				return fmt.Errorf("service account %s: %w", uid, err)
+			}
+		}
+		if svcacct.PasswordHash != "" {
+			if err := model.ValidatePasswordHash(svcacct.PasswordHash); err != nil {
+				return fmt.Errorf("service account %s: %w", uid, err)
+			}
+		}
+		for i, key := range svcacct.SSHKeys {
+			if err := model.ValidateSSHKey(key); err != nil {
+				return fmt.Errorf("service account %s: SSH key %d: %w", uid, i+1, err)
+			}
+		}
+		for _, groupname := range svcacct.Groups {
+			if _, ok := c.LDAPEnforcer.Group[groupname]; !ok {
+				return fmt.Errorf("service account %s: unknown group %s", uid, groupname)
+			}
+		}
+		if err := c.validatePrimaryGroup(svcacct.PrimaryGroup, svcacct.Posix); err != nil {
+			return fmt.Errorf("service account %s: %w", uid, err)
+		}
+	}
+
+	// Templates are applied at load time, but unused templates are checked too
+	for name, template := range c.LDAPEnforcer.Template {
+		if err := validatePosixPaths(template.LoginShell, ""); err != nil {
+			return fmt.Errorf("template %s: %w", name, err)
+		}
+		if template.HomeDirectoryBase != "" && !strings.HasPrefix(template.HomeDirectoryBase, "/") {
+			return fmt.Errorf("template %s: home_directory_base must be an absolute path: %s", name, template.HomeDirectoryBase)
+		}
+		if err := model.ValidateExtraAttributes(template.Attributes, template.ExtraObjectClasses); err != nil {
+			return fmt.Errorf("template %s: %w", name, err)
+		}
+		for _, groupname := range template.Groups {
+			if _, ok := c.LDAPEnforcer.Group[groupname]; !ok {
+				return fmt.Errorf("template %s: unknown group %s", name, groupname)
+			}
+		}
+	}
+
+	if err := validateIDRange("uid_range", c.LDAPEnforcer.UIDRange); err != nil {
+		return err
+	}
+	if err := validateIDRange("gid_range", c.LDAPEnforcer.GIDRange); err != nil {
+		return err
+	}
+	for uid, person := range c.LDAPEnforcer.Person {
+		if person.AutoPosix && !person.IsPosix() && len(c.LDAPEnforcer.UIDRange) == 0 {
+			return fmt.Errorf("person %s: auto_posix requires uid_range", uid)
+		}
+	}
+	for uid, svcacct := range c.LDAPEnforcer.SvcAcct {
+		if svcacct.AutoPosix && !svcacct.IsPosix() && len(c.LDAPEnforcer.UIDRange) == 0 {
+			return fmt.Errorf("service account %s: auto_posix requires uid_range", uid)
+		}
+	}
+	for groupname, group := range c.LDAPEnforcer.Group {
+		if group.AutoPosix && !group.IsPosix() && len(c.LDAPEnforcer.GIDRange) == 0 {
+			return fmt.Errorf("group %s: auto_posix requires gid_range", groupname)
+		}
+	}
+
+	// People with auto_posix are checked once their numbers are allocated
+	if _, err := c.PrivateGroups(); err != nil {
+		return err
+	}
+
+	if err := model.ValidateNesting(c.LDAPEnforcer.GroupNesting); err != nil {
+		return fmt.Errorf("group_nesting: %w", err)
+	}
+	if _, err := ldap.ParseDN(c.LDAPEnforcer.EmptyGroupPlaceholder); c.LDAPEnforcer.EmptyGroupPlaceholder != "" && err != nil {
+		return fmt.Errorf("invalid empty_group_placeholder %q", c.LDAPEnforcer.EmptyGroupPlaceholder)
+	}
+	for groupname, group := range c.LDAPEnforcer.Group {
+		if err := model.ValidateNesting(group.Nesting); err != nil {
+			return fmt.Errorf("group %s: %w", groupname, err)
+		}
+		for i := range group.Include {
+			if err := group.Include[i].Validate(); err != nil {
+				return fmt.Errorf("group %s: %w", groupname, err)
+			}
+		}
+		if err := model.ValidateExtraAttributes(group.Attributes, group.ExtraObjectClasses); err != nil {
+			return fmt.Errorf("group %s: %w", groupname, err)
+		}
+		for _, dn := range group.ExternalMembers {
+			if _, err := ldap.ParseDN(dn); dn == "" || err != nil {
+				return fmt.Errorf("group %s: invalid external member DN %q", groupname, dn)
+			}
+			// Entries in the enforced OUs are managed, and belong in people, svcaccts, or groups
+			if c.withinEnforcedOU(dn) {
+				return fmt.Errorf("group %s: external member %s is inside an enforced OU", groupname, dn)
+			}
+		}
+	}
+
+	return nil
+}
+
+// withinEnforcedOU returns true if dn is one of the enforced OUs or an entry below one
+func (c *Config) withinEnforcedOU(dn string) bool {
+	dn = strings.ToLower(dn)
+	for _, ou := range []string{c.LDAPEnforcer.EnforcedPeopleOU, c.LDAPEnforcer.EnforcedSvcAcctOU, c.LDAPEnforcer.EnforcedGroupOU, c.LDAPEnforcer.PrivateGroupOU} {
+		if ou == "" {
+			continue
+		}
+		ou = strings.ToLower(ou)
+		if dn == ou || strings.HasSuffix(dn, ","+ou) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateIDRange checks a uid_range or gid_range setting, which is either unset or [first, last]
+func validateIDRange(name string, idRange []int) error {
+	if len(idRange) == 0 {
+		return nil
+	}
+	if len(idRange) != 2 || idRange[0] <= 0 || idRange[0] > idRange[1] {
+		return fmt.Errorf("%s must be [first, last] with 0 < first <= last: %v", name, idRange)
+	}
+	return nil
+}
+
+// validatePrimaryGroup checks that a primary group exists and has a GID number, or will have one allocated,
+// and that the account does not also set a GID number in posix
+func (c *Config) validatePrimaryGroup(groupname string, posix []int) error {
+	if groupname == "" {
+		return nil
+	}
+	group, ok := c.LDAPEnforcer.Group[groupname]
+	if !ok {
+		return fmt.Errorf("unknown primary group %s", groupname)
+	}
+	if !group.IsPosix() && !group.AutoPosix {
+		return fmt.Errorf("primary group %s is not a POSIX group", groupname)
+	}
+	if len(posix) > 1 {
+		return fmt.Errorf("posix must list only the UID number when primary_group is set")
+	}
 	return nil
 }
 
+// validatePosixPaths checks the per-entity login shell and home directory overrides
+func validatePosixPaths(loginShell, homeDirectory string) error {
+	if loginShell != "" && !strings.HasPrefix(loginShell, "/") {
+		return fmt.Errorf("login_shell must be an absolute path: %s", loginShell)
+	}
+	if homeDirectory != "" && !strings.HasPrefix(homeDirectory, "/") {
+		return fmt.Errorf("home_directory must be an absolute path: %s", homeDirectory)
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with password hashes replaced,
+// suitable for displaying to the user
+func (c *Config) Redacted() *Config {
+	redacted := *c
+
+	redacted.LDAPEnforcer.Person = make(map[string]*model.Person, len(c.LDAPEnforcer.Person))
+	for uid, person := range c.LDAPEnforcer.Person {
+		copied := *person
+		if copied.PasswordHash != "" {
+			copied.PasswordHash = model.RedactedPassword
+		}
+		redacted.LDAPEnforcer.Person[uid] = &copied
+	}
+
+	redacted.LDAPEnforcer.SvcAcct = make(map[string]*model.SvcAcct, len(c.LDAPEnforcer.SvcAcct))
+	for uid, svcacct := range c.LDAPEnforcer.SvcAcct {
+		copied := *svcacct
+		if copied.PasswordHash != "" {
+			copied.PasswordHash = model.RedactedPassword
+		}
+		redacted.LDAPEnforcer.SvcAcct[uid] = &copied
+	}
+
+	return &redacted
+}
+
+// GetPersonLoginShell returns the login shell for a POSIX person
+func (c *Config) GetPersonLoginShell(person *model.Person) string {
+	if person.LoginShell != "" {
+		return person.LoginShell
+	}
+	if c.LDAPEnforcer.DefaultLoginShell != "" {
+		return c.LDAPEnforcer.DefaultLoginShell
+	}
+	return DefaultLoginShell
+}
+
+// GetSvcAcctLoginShell returns the login shell for a POSIX service account
+func (c *Config) GetSvcAcctLoginShell(svcacct *model.SvcAcct) string {
+	if svcacct.LoginShell != "" {
+		return svcacct.LoginShell
+	}
+	if c.LDAPEnforcer.SvcAcctLoginShell != "" {
+		return c.LDAPEnforcer.SvcAcctLoginShell
+	}
+	return DefaultSvcAcctLoginShell
+}
+
+// GetHomeDirectory returns the home directory for a POSIX account:
+// the override if set, otherwise <home_directory_base>/<uid>,
+// or /nonexistent if the account has no username
+func (c *Config) GetHomeDirectory(username, override string) string {
+	if override != "" {
+		return override
+	}
+	if username == "" {
+		return "/nonexistent"
+	}
+	base := c.LDAPEnforcer.HomeDirectoryBase
+	if base == "" {
+		base = DefaultHomeDirectoryBase
+	}
+	return path.Join(base, username)
+}
+
+// GetGroupNesting returns how a group represents its nested groups:
+// the group's own nesting, then the global group_nesting, then flatten
+func (c *Config) GetGroupNesting(group *model.Group) string {
+	if group.Nesting != "" {
+		return group.Nesting
+	}
+	if c.LDAPEnforcer.GroupNesting != "" {
+		return c.LDAPEnforcer.GroupNesting
+	}
+	return model.NestingFlatten
+}
+
+// GetPrivateGroupOU returns the OU that user private groups are created in
+func (c *Config) GetPrivateGroupOU() string {
+	if c.LDAPEnforcer.PrivateGroupOU != "" {
+		return c.LDAPEnforcer.PrivateGroupOU
+	}
+	return c.LDAPEnforcer.EnforcedGroupOU
+}
+
+// HasUserPrivateGroup returns true if a person gets a user private group.
+// Only POSIX people can have one, because the group's GID number is the person's,
+// and people with a primary group already have a group for their GID number.
+func (c *Config) HasUserPrivateGroup(person *model.Person) bool {
+	if !person.IsPosix() || person.PrimaryGroup != "" {
+		return false
+	}
+	if person.UserPrivateGroup != nil {
+		return *person.UserPrivateGroup
+	}
+	return c.LDAPEnforcer.UserPrivateGroup
+}
+
+// ResolvePrimaryGroups sets the GID number of each account that names a primary_group to that group's GID number,
+// and defaults its primary_group_member to the global setting.
+func (c *Config) ResolvePrimaryGroups() {
+	for _, person := range c.LDAPEnforcer.Person {
+		person.Posix = c.primaryGroupPosix(person.PrimaryGroup, person.Posix)
+		if person.PrimaryGroup != "" && person.PrimaryGroupMember == nil {
+			member := c.LDAPEnforcer.PrimaryGroupMember
+			person.PrimaryGroupMember = &member
+		}
+	}
+	for _, svcacct := range c.LDAPEnforcer.SvcAcct {
+		svcacct.Posix = c.primaryGroupPosix(svcacct.PrimaryGroup, svcacct.Posix)
+		if svcacct.PrimaryGroup != "" && svcacct.PrimaryGroupMember == nil {
+			member := c.LDAPEnforcer.PrimaryGroupMember
+			svcacct.PrimaryGroupMember = &member
+		}
+	}
+}
+
+// primaryGroupPosix returns an account's POSIX numbers with the GID number of its primary group
+func (c *Config) primaryGroupPosix(groupname string, posix []int) []int {
+	if groupname == "" || len(posix) == 0 {
+		return posix
+	}
+	group, ok := c.LDAPEnforcer.Group[groupname]
+	if !ok || !group.IsPosix() {
+		return posix
+	}
+	return []int{posix[0], group.PosixGidNumber}
+}
+
+// PrivateGroups returns the user private groups of POSIX people, by group name.
+// It returns an error if a private group would have the same name as another group.
+// A person whose GID number another group already uses gets no private group, with a warning,
+// since that group already serves as their primary group.
+func (c *Config) PrivateGroups() (map[string]*model.Group, error) {
+	gidOwners := make(map[int]string)
+	for groupname, group := range c.LDAPEnforcer.Group {
+		if group.IsPosix() && !group.Private {
+			gidOwners[group.PosixGidNumber] = "group " + groupname
+		}
+	}
+
+	privateGroups := make(map[string]*model.Group)
+	uids := make([]string, 0, len(c.LDAPEnforcer.Person))
+	for uid := range c.LDAPEnforcer.Person {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	for _, uid := range uids {
+		person := c.LDAPEnforcer.Person[uid]
+		if !c.HasUserPrivateGroup(person) {
+			continue
+		}
+		if group, ok := c.LDAPEnforcer.Group[uid]; ok && !group.Private {
+			return nil, fmt.Errorf("person %s: user private group conflicts with group %s", uid, uid)
+		}
+		gid := person.GetGIDNumber()
+		if owner, ok := gidOwners[gid]; ok {
+			logging.DefaultLogger.Warn("Skipping the user private group of person %s: GID number %d is already used by %s", uid, gid, owner)
+			continue
+		}
+		gidOwners[gid] = "the user private group of " + uid
+		privateGroups[uid] = &model.Group{
+			Description:    fmt.Sprintf("User private group for %s", uid),
+			PosixGidNumber: gid,
+			People:         []string{uid},
+			Private:        true,
+		}
+	}
+	return privateGroups, nil
+}
+
+// GetPosixStateFile returns the path of the POSIX number state file,
+// relative paths being relative to the main config file, or an empty string if none is configured
+func (c *Config) GetPosixStateFile() string {
+	statePath := c.LDAPEnforcer.PosixStateFile
+	if statePath == "" || filepath.IsAbs(statePath) {
+		return statePath
+	}
+	if dir, err := GetConfigDir(); err == nil {
+		return filepath.Join(dir, statePath)
+	}
+	return statePath
+}
+
+// GetEmptyGroupPlaceholder returns the member DN used for groups with allow_empty that have no members
+func (c *Config) GetEmptyGroupPlaceholder() string {
+	if c.LDAPEnforcer.EmptyGroupPlaceholder != "" {
+		return c.LDAPEnforcer.EmptyGroupPlaceholder
+	}
+	return DefaultEmptyGroupPlaceholder
+}
+
+// NextExpiry returns the earliest account expiry time after now,
+// so that a long-running sync can lock the account when it passes
+func (c *Config) NextExpiry(now time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	consider := func(expiresAt time.Time, ok bool) {
+		if ok && expiresAt.After(now) && (!found || expiresAt.Before(next)) {
+			next = expiresAt
+			found = true
+		}
+	}
+	for _, person := range c.LDAPEnforcer.Person {
+		consider(person.ExpiresAt())
+	}
+	for _, svcacct := range c.LDAPEnforcer.SvcAcct {
+		consider(svcacct.ExpiresAt())
+	}
+	return next, found
+}
+
+// GetQuarantineRetention returns how long quarantined entries are kept,
+// or 0 if they should be kept forever
+func (c *Config) GetQuarantineRetention() (time.Duration, error) {
+	if c.LDAPEnforcer.QuarantineRetention == "" {
+		return 0, nil
+	}
+	retention, err := time.ParseDuration(c.LDAPEnforcer.QuarantineRetention)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quarantine_retention: %w", err)
+	}
+	if retention < 0 {
+		return 0, fmt.Errorf("quarantine_retention must not be negative")
+	}
+	return retention, nil
+}
+
+// Hash returns a stable hash of every setting that affects the desired directory state,
+// including the POSIX numbers and private groups filled in when preparing a sync.
+// Connection settings, credentials, and operational settings like logging and deletion limits
+// are not included, so the hash only changes when the desired directory state changes.
+func (c *Config) Hash() (string, error) {
+	hashed := c.LDAPEnforcer
+	hashed.URI = ""
+	hashed.BindDN = ""
+	hashed.Password = ""
+	hashed.PasswordFile = ""
+	hashed.PasswordCommand = ""
+	hashed.PasswordCommandViaShell = false
+	hashed.CACertFile = ""
+	hashed.MainLogLevel = ""
+	hashed.LDAPLogLevel = ""
+	hashed.PollConfigInterval = ""
+	hashed.PollLDAPInterval = ""
+	hashed.MaxDeletions = 0
+	hashed.MaxDeletionPercent = 0
+	hashed.AllowMassDelete = false
+	hashed.AllowConfigChange = false
+	hashed.ContinueOnError = false
+	hashed.PosixStateFile = ""
+	hashed.Includes = nil
+
+	// encoding/json sorts map keys, so the output is stable
+	data, err := json.Marshal(hashed)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode configuration for hashing: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// parseIntList parses a comma-separated list of integers
+func parseIntList(val string) ([]int, error) {
+	var result []int
+	for _, part := range strings.Split(val, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
 // parseCommandString parses a command string into command and arguments
 // This handles quoted arguments correctly
 func parseCommandString(command string) ([]string, error) {
@@ -3,7 +3,11 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mrled/ldapenforcer/internal/model"
 	"github.com/spf13/pflag"
@@ -306,6 +310,364 @@ func TestValidate(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name: "With quarantine OU and retention",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:                 "ldap://example.com",
+					BindDN:              "cn=admin,dc=example,dc=com",
+					Password:            "password",
+					EnforcedPeopleOU:    "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU:   "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:     "ou=managed,ou=groups,dc=example,dc=com",
+					QuarantineOU:        "ou=quarantine,dc=example,dc=com",
+					QuarantineRetention: "720h",
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "Quarantine OU inside an enforced OU",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					QuarantineOU:      "ou=quarantine,ou=managed,ou=people,dc=example,dc=com",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Invalid quarantine retention",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:                 "ldap://example.com",
+					BindDN:              "cn=admin,dc=example,dc=com",
+					Password:            "password",
+					EnforcedPeopleOU:    "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU:   "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:     "ou=managed,ou=groups,dc=example,dc=com",
+					QuarantineOU:        "ou=quarantine,dc=example,dc=com",
+					QuarantineRetention: "30 days",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Relative home directory base",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					HomeDirectoryBase: "home",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Relative person login shell",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					Person: map[string]*model.Person{
+						"john": {CN: "John Doe", LoginShell: "zsh"},
+					},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Reserved group attribute",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					Group: map[string]*model.Group{
+						"admins": {Description: "Admins", Attributes: map[string][]string{"member": {"uid=root,dc=example,dc=com"}}},
+					},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Person in an unknown group",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					Person: map[string]*model.Person{
+						"john": {CN: "John Doe", Groups: []string{"nonexistent"}},
+					},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Service account in an unknown group",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					SvcAcct: map[string]*model.SvcAcct{
+						"backup": {CN: "Backup", Description: "Backup service", Groups: []string{"nonexistent"}},
+					},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Invalid uid_range",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					UIDRange:          []int{29999, 20000},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "auto_posix without uid_range",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					Person: map[string]*model.Person{
+						"john": {CN: "John Doe", AutoPosix: true},
+					},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Group auto_posix without gid_range",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					UIDRange:          []int{20000, 29999},
+					Group: map[string]*model.Group{
+						"admins": {Description: "Admins", AutoPosix: true},
+					},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "User private group named like a group",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					UserPrivateGroup:  true,
+					Person: map[string]*model.Person{
+						"admins": {CN: "Admin", Posix: []int{1001, 1001}},
+					},
+					Group: map[string]*model.Group{
+						"admins": {Description: "Admins", People: []string{"admins"}},
+					},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "User private groups with the same GID number are skipped",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					UserPrivateGroup:  true,
+					Person: map[string]*model.Person{
+						"john": {CN: "John Doe", Posix: []int{1001, 100}},
+						"jane": {CN: "Jane Doe", Posix: []int{1002, 100}},
+					},
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "Unknown primary group",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					Person: map[string]*model.Person{
+						"john": {CN: "John Doe", Posix: []int{1001}, PrimaryGroup: "employees"},
+					},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Primary group that is not POSIX",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					Person: map[string]*model.Person{
+						"john": {CN: "John Doe", Posix: []int{1001}, PrimaryGroup: "employees"},
+					},
+					Group: map[string]*model.Group{
+						"employees": {Description: "Employees"},
+					},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Primary group with a GID number in posix",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					Person: map[string]*model.Person{
+						"john": {CN: "John Doe", Posix: []int{1001, 100}, PrimaryGroup: "employees"},
+					},
+					Group: map[string]*model.Group{
+						"employees": {Description: "Employees", PosixGidNumber: 100},
+					},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Invalid include rule kind",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					Group: map[string]*model.Group{
+						"eng": {Description: "Engineering", Include: model.MemberRules{{Kind: "people"}}},
+					},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Invalid group nesting",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					GroupNesting:      "nested",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Invalid empty group placeholder",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:                   "ldap://example.com",
+					BindDN:                "cn=admin,dc=example,dc=com",
+					Password:              "password",
+					EnforcedPeopleOU:      "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU:     "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:       "ou=managed,ou=groups,dc=example,dc=com",
+					EmptyGroupPlaceholder: "nobody",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "External member inside an enforced OU",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					Group: map[string]*model.Group{
+						"admins": {Description: "Admins", ExternalMembers: []string{"uid=john,ou=managed,ou=people,dc=example,dc=com"}},
+					},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "External member outside the enforced OUs",
+			config: &Config{
+				LDAPEnforcer: LDAPEnforcerConfig{
+					URI:               "ldap://example.com",
+					BindDN:            "cn=admin,dc=example,dc=com",
+					Password:          "password",
+					EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+					EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+					EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+					Group: map[string]*model.Group{
+						"admins": {Description: "Admins", ExternalMembers: []string{"uid=backup,ou=legacy,dc=example,dc=com"}},
+					},
+				},
+			},
+			expectError: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -322,6 +684,84 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestPrivateGroupsSkipsGIDConflicts(t *testing.T) {
+	config := &Config{
+		LDAPEnforcer: LDAPEnforcerConfig{
+			UserPrivateGroup: true,
+			Person: map[string]*model.Person{
+				"alice": {CN: "Alice", Posix: []int{1001, 1001}},
+				"bob":   {CN: "Bob", Posix: []int{1002, 1001}},
+				"carol": {CN: "Carol", Posix: []int{1003, 500}},
+				"dave":  {CN: "Dave", Posix: []int{1004, 1004}},
+			},
+			Group: map[string]*model.Group{
+				"staff": {Description: "Staff", PosixGidNumber: 500, People: []string{"carol"}},
+			},
+		},
+	}
+
+	privateGroups, err := config.PrivateGroups()
+	if err != nil {
+		t.Fatalf("PrivateGroups failed: %v", err)
+	}
+
+	// bob shares alice's GID number and carol uses staff's, so only they go without a private group
+	var names []string
+	for name := range privateGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if expected := []string{"alice", "dave"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected private groups %v, got %v", expected, names)
+	}
+}
+
+func TestHash(t *testing.T) {
+	newConfig := func() *Config {
+		return &Config{
+			LDAPEnforcer: LDAPEnforcerConfig{
+				Password:         "password",
+				EnforcedPeopleOU: "ou=managed,ou=people,dc=example,dc=com",
+				Person: map[string]*model.Person{
+					"john": {CN: "John Doe"},
+				},
+			},
+		}
+	}
+
+	base, err := newConfig().Hash()
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+
+	// The hash should be stable
+	again, _ := newConfig().Hash()
+	if base != again {
+		t.Errorf("Expected identical configs to hash the same, got %s and %s", base, again)
+	}
+
+	// Credentials should not affect the hash
+	cfg := newConfig()
+	cfg.LDAPEnforcer.Password = "other"
+	if h, _ := cfg.Hash(); h != base {
+		t.Error("Expected the password not to affect the hash")
+	}
+
+	// Global settings that change entries should affect the hash
+	cfg = newConfig()
+	cfg.LDAPEnforcer.DefaultLoginShell = "/bin/zsh"
+	if h, _ := cfg.Hash(); h == base {
+		t.Error("Expected a changed default_login_shell to change the hash")
+	}
+
+	// Managed objects should affect the hash
+	cfg = newConfig()
+	cfg.LDAPEnforcer.Person["john"].Mail = "john@example.com"
+	if h, _ := cfg.Hash(); h == base {
+		t.Error("Expected a changed person to change the hash")
+	}
+}
+
 func TestParseCommandString(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -522,3 +962,286 @@ func TestMergeWithEnv(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadConfigPasswordFile(t *testing.T) {
+	dir := t.TempDir()
+	hash := "{SSHA512}dGhpcyBpcyBub3QgYSByZWFsIGhhc2g="
+	if err := os.WriteFile(filepath.Join(dir, "alice.hash"), []byte(hash+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write password file: %v", err)
+	}
+
+	configPath := filepath.Join(dir, "config.toml")
+	configData := `
+[ldapenforcer.person.alice]
+cn = "Alice"
+password_file = "alice.hash"
+
+[ldapenforcer.svcacct.backup]
+cn = "Backup"
+description = "Backup service"
+password_hash = "{PBKDF2_SHA256}AAAIAFnD4ZDfuL6Ke6hXWlQ5"
+`
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if got := config.LDAPEnforcer.Person["alice"].PasswordHash; got != hash {
+		t.Errorf("Expected password hash from file, got %q", got)
+	}
+
+	// Password hashes are redacted for display without changing the config itself
+	redacted := config.Redacted()
+	if got := redacted.LDAPEnforcer.Person["alice"].PasswordHash; got != model.RedactedPassword {
+		t.Errorf("Expected redacted person password hash, got %q", got)
+	}
+	if got := redacted.LDAPEnforcer.SvcAcct["backup"].PasswordHash; got != model.RedactedPassword {
+		t.Errorf("Expected redacted service account password hash, got %q", got)
+	}
+	if config.LDAPEnforcer.Person["alice"].PasswordHash != hash {
+		t.Error("Redacted must not modify the original config")
+	}
+
+	// Setting both is an error
+	configData += "password_file = \"backup.hash\"\n"
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected an error when password_hash and password_file are both set")
+	}
+}
+
+func TestValidateRejectsPlaintextPassword(t *testing.T) {
+	config := &Config{
+		LDAPEnforcer: LDAPEnforcerConfig{
+			URI:               "ldap://example.com",
+			BindDN:            "cn=admin,dc=example,dc=com",
+			Password:          "password",
+			EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+			EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+			EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+			Person: map[string]*model.Person{
+				"alice": {CN: "Alice", PasswordHash: "hunter2"},
+			},
+		},
+	}
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("Expected an error for a plain-text password")
+	}
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Error must not include the password: %v", err)
+	}
+}
+
+func TestNextExpiry(t *testing.T) {
+	config := &Config{
+		LDAPEnforcer: LDAPEnforcerConfig{
+			Person: map[string]*model.Person{
+				"expired": {CN: "Expired", Expires: "2026-01-01"},
+				"later":   {CN: "Later", Expires: "2027-03-01"},
+				"never":   {CN: "Never"},
+			},
+			SvcAcct: map[string]*model.SvcAcct{
+				"soon": {CN: "Soon", Expires: "2026-07-01T09:30:00Z"},
+			},
+		},
+	}
+
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	next, ok := config.NextExpiry(now)
+	if !ok {
+		t.Fatal("Expected a next expiry")
+	}
+	if expected := time.Date(2026, 7, 1, 9, 30, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Errorf("Expected next expiry %s, got %s", expected, next)
+	}
+
+	if _, ok := config.NextExpiry(time.Date(2028, 1, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Error("Expected no next expiry once every account has expired")
+	}
+}
+
+func TestLoadConfigTemplates(t *testing.T) {
+	dir := t.TempDir()
+
+	// Templates can be defined in an included file
+	templatesData := `
+[ldapenforcer.template.base]
+login_shell = "/bin/zsh"
+extra_object_classes = ["extensibleObject"]
+groups = ["everyone"]
+
+[ldapenforcer.template.employee]
+template = "base"
+gidNumber = 10100
+home_directory_base = "/home/employees"
+groups = ["employees"]
+attributes = { departmentNumber = ["100"] }
+`
+	if err := os.WriteFile(filepath.Join(dir, "templates.toml"), []byte(templatesData), 0600); err != nil {
+		t.Fatalf("Failed to write templates file: %v", err)
+	}
+
+	configPath := filepath.Join(dir, "config.toml")
+	configData := `
+[ldapenforcer]
+includes = ["templates.toml"]
+
+[ldapenforcer.person.alice]
+cn = "Alice"
+template = "employee"
+posix = [10001]
+
+[ldapenforcer.person.bob]
+cn = "Bob"
+template = "employee"
+posix = [10002, 20000]
+login_shell = "/bin/bash"
+groups = ["admins"]
+attributes = { departmentNumber = ["200"] }
+
+[ldapenforcer.svcacct.backup]
+cn = "Backup"
+description = "Backup service"
+template = "base"
+`
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	alice := config.LDAPEnforcer.Person["alice"]
+	if !reflect.DeepEqual(alice.Posix, []int{10001, 10100}) {
+		t.Errorf("Expected alice to get the template GID, got %v", alice.Posix)
+	}
+	if alice.LoginShell != "/bin/zsh" {
+		t.Errorf("Expected alice to inherit the login shell, got %q", alice.LoginShell)
+	}
+	if alice.HomeDirectory != "/home/employees/alice" {
+		t.Errorf("Expected alice's home directory under the template base, got %q", alice.HomeDirectory)
+	}
+	if !reflect.DeepEqual(alice.Groups, []string{"everyone", "employees"}) {
+		t.Errorf("Expected alice to get the inherited groups, got %v", alice.Groups)
+	}
+	if !reflect.DeepEqual(alice.ExtraObjectClasses, []string{"extensibleObject"}) {
+		t.Errorf("Expected alice to get the inherited object classes, got %v", alice.ExtraObjectClasses)
+	}
+
+	// Fields set on the person override the template
+	bob := config.LDAPEnforcer.Person["bob"]
+	if !reflect.DeepEqual(bob.Posix, []int{10002, 20000}) {
+		t.Errorf("Expected bob to keep his GID, got %v", bob.Posix)
+	}
+	if bob.LoginShell != "/bin/bash" {
+		t.Errorf("Expected bob to keep his login shell, got %q", bob.LoginShell)
+	}
+	if !reflect.DeepEqual(bob.Groups, []string{"everyone", "employees", "admins"}) {
+		t.Errorf("Expected bob's groups to be added to the template's, got %v", bob.Groups)
+	}
+	if !reflect.DeepEqual(bob.Attributes["departmentNumber"], []string{"200"}) {
+		t.Errorf("Expected bob's attribute to override the template, got %v", bob.Attributes)
+	}
+
+	if backup := config.LDAPEnforcer.SvcAcct["backup"]; backup.LoginShell != "/bin/zsh" {
+		t.Errorf("Expected the service account to use the template, got login shell %q", backup.LoginShell)
+	}
+
+	// Inheritance cycles are rejected
+	cycleData := configData + `
+[ldapenforcer.template.a]
+template = "b"
+
+[ldapenforcer.template.b]
+template = "a"
+`
+	if err := os.WriteFile(configPath, []byte(cycleData), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := LoadConfig(configPath); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("Expected an inheritance cycle error, got %v", err)
+	}
+
+	// So are unknown templates
+	unknownData := configData + `
+[ldapenforcer.person.carol]
+cn = "Carol"
+template = "contractor"
+`
+	if err := os.WriteFile(configPath, []byte(unknownData), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected an error for an unknown template")
+	}
+}
+
+func TestLoadConfigIncludeRules(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	configData := `
+[ldapenforcer.person.alice]
+cn = "Alice"
+labels = { dept = "eng" }
+
+[ldapenforcer.group.eng]
+description = "Engineering"
+include = { kind = "person", labels = { dept = "eng" } }
+
+[ldapenforcer.group.builders]
+description = "Builders"
+include = [
+  { kind = "person", labels = { dept = "eng" } },
+  { kind = "svcacct" },
+]
+
+[ldapenforcer.group.everyone]
+description = "Everyone"
+include_all_people = true
+`
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if labels := config.LDAPEnforcer.Person["alice"].Labels; !reflect.DeepEqual(labels, map[string]string{"dept": "eng"}) {
+		t.Errorf("Expected alice to have the dept label, got %v", labels)
+	}
+	// A single table is a list of one rule
+	expected := model.MemberRules{{Kind: "person", Labels: map[string]string{"dept": "eng"}}}
+	if include := config.LDAPEnforcer.Group["eng"].Include; !reflect.DeepEqual(include, expected) {
+		t.Errorf("Expected eng to have rules %v, got %v", expected, include)
+	}
+	expected = model.MemberRules{{Kind: "person", Labels: map[string]string{"dept": "eng"}}, {Kind: "svcacct"}}
+	if include := config.LDAPEnforcer.Group["builders"].Include; !reflect.DeepEqual(include, expected) {
+		t.Errorf("Expected builders to have rules %v, got %v", expected, include)
+	}
+	if !config.LDAPEnforcer.Group["everyone"].IncludeAllPeople {
+		t.Errorf("Expected everyone to include all people")
+	}
+
+	// Rules with unknown keys are rejected
+	configData = `
+[ldapenforcer.group.eng]
+description = "Engineering"
+include = { kind = "person", label = { dept = "eng" } }
+`
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Errorf("Expected an error for an unknown include rule key")
+	}
+}
@@ -0,0 +1,169 @@
+package ldap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mrled/ldapenforcer/internal/config"
+	"github.com/mrled/ldapenforcer/internal/logging"
+)
+
+// ApplyPlan executes exactly the changes in a previously computed plan.
+// It refuses to make any change if the directory has drifted since the plan was made.
+func (c *Client) ApplyPlan(plan *SyncPlan) error {
+	return applySyncPlan(c, c.config, plan)
+}
+
+// ApplyPlan executes exactly the changes in a previously computed plan against the mock state
+func (m *MockClient) ApplyPlan(plan *SyncPlan) error {
+	return applySyncPlan(m, m.config, plan)
+}
+
+// applySyncPlan checks a plan for drift and then applies its changes in order
+func applySyncPlan(c LDAPClientInterface, cfg *config.Config, plan *SyncPlan) error {
+	// Prepare the configuration the same way the plan did, so that the hashes are comparable
+	if _, err := prepareConfig(c, cfg); err != nil {
+		return err
+	}
+	configHash, err := cfg.Hash()
+	if err != nil {
+		return err
+	}
+	if configHash != plan.ConfigHash {
+		if !cfg.LDAPEnforcer.AllowConfigChange {
+			return fmt.Errorf("refusing to apply plan: the configuration has changed since the plan was made; no changes were made (use --allow-config-change to override)")
+		}
+		logging.DefaultLogger.Warn("Current configuration differs from the configuration the plan was made from; applying the plan as reviewed")
+	}
+
+	// Refuse to apply a stale plan
+	if err := checkPlanDrift(c, cfg, plan); err != nil {
+		return err
+	}
+
+	// Check the deletion limits before making any change
+	if err := checkDeletionLimits(cfg, plan.deletions(), plan.existingCount()); err != nil {
+		return err
+	}
+
+	// Record the POSIX numbers allocated for the plan and the attribute names it writes before any entry uses them
+	err = recordPosixAllocations(cfg, plan.PosixAllocations)
+	if err != nil {
+		return err
+	}
+	err = recordWrittenState(c, cfg)
+	if err != nil {
+		return err
+	}
+
+	err = c.EnsureManagedOUsExist()
+	if err != nil {
+		return err
+	}
+
+	for _, change := range plan.Changes {
+		if err := applyChange(c, change); err != nil {
+			return fmt.Errorf("failed to %s %s %s: %w", change.Action, change.EntityType, change.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// checkPlanDrift returns an error listing every entry whose state differs from when the plan was made,
+// including entries that have appeared in the enforced OUs since then
+func checkPlanDrift(c LDAPClientInterface, cfg *config.Config, plan *SyncPlan) error {
+	var drifted []string
+	planned := make(map[string]bool, len(plan.Changes))
+
+	for _, change := range plan.Changes {
+		planned[strings.ToLower(change.DN)] = true
+
+		current, err := c.GetEntryAttributes(change.DN)
+		if err != nil {
+			return fmt.Errorf("failed to check %s for drift: %w", change.DN, err)
+		}
+		if fingerprintEntry(current) != change.Fingerprint {
+			drifted = append(drifted, change.DN)
+		}
+	}
+
+	ous := []struct {
+		ou         string
+		entryType  string
+		entityName string
+	}{
+		{cfg.LDAPEnforcer.EnforcedPeopleOU, "person", "people"},
+		{cfg.LDAPEnforcer.EnforcedSvcAcctOU, "svcacct", "service accounts"},
+		{cfg.LDAPEnforcer.EnforcedGroupOU, "group", "groups"},
+	}
+	if cfg.LDAPEnforcer.PrivateGroupOU != "" && !strings.EqualFold(cfg.LDAPEnforcer.PrivateGroupOU, cfg.LDAPEnforcer.EnforcedGroupOU) {
+		ous = append(ous, struct {
+			ou         string
+			entryType  string
+			entityName string
+		}{cfg.LDAPEnforcer.PrivateGroupOU, "group", "private groups"})
+	}
+	for _, ou := range ous {
+		existing, err := c.GetExistingEntries(ou.ou, ou.entryType)
+		if err != nil {
+			return fmt.Errorf("failed to get existing %s: %w", ou.entityName, err)
+		}
+		for _, dn := range sortedKeys(existing) {
+			if !planned[strings.ToLower(dn)] {
+				drifted = append(drifted, dn)
+			}
+		}
+	}
+
+	if len(drifted) > 0 {
+		for _, dn := range drifted {
+			logging.DefaultLogger.Error("Entry has changed since the plan was made: %s", dn)
+		}
+		return fmt.Errorf("directory has drifted since the plan was made (%d entries changed); create a new plan", len(drifted))
+	}
+
+	return nil
+}
+
+// applyChange performs the LDAP operations for a single planned change
+func applyChange(c LDAPClientInterface, change *EntityChange) error {
+	switch change.Action {
+	case ChangeCreate:
+		logging.LDAPProtocolLogger.Trace("Creating %s: %s", change.EntityType, change.DN)
+		attrs := make(map[string][]string, len(change.Attributes))
+		for _, attr := range change.Attributes {
+			attrs[attr.Name] = attr.New
+		}
+		return c.CreateEntry(change.DN, attrs)
+
+	case ChangeModify:
+		logging.LDAPProtocolLogger.Trace("Updating %s: %s", change.EntityType, change.DN)
+		return c.ModifyEntryAttributes(change.DN, change.Attributes)
+
+	case ChangeDelete:
+		logging.LDAPProtocolLogger.Trace("Deleting %s: %s", change.EntityType, change.DN)
+		return c.DeleteEntry(change.DN)
+
+	case ChangeQuarantine, ChangeRestore:
+		logging.LDAPProtocolLogger.Trace("Moving %s %s to %s", change.EntityType, change.DN, change.NewSuperior)
+		if err := c.MoveEntry(change.DN, change.NewSuperior); err != nil {
+			return err
+		}
+		if len(change.Attributes) == 0 {
+			return nil
+		}
+		return c.ModifyEntryAttributes(change.TargetDN(), change.Attributes)
+
+	case ChangePurge:
+		logging.LDAPProtocolLogger.Trace("Purging quarantined %s: %s", change.EntityType, change.DN)
+		return c.DeleteEntry(change.DN)
+
+	case ChangeUnchanged:
+		logging.LDAPProtocolLogger.Trace("No changes for %s: %s", change.EntityType, change.DN)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown change action: %s", change.Action)
+	}
+}
@@ -23,13 +23,22 @@ type LDAPClientInterface interface {
 	PersonToDN(uid string) string
 	SvcAcctToDN(uid string) string
 	GroupToDN(groupname string) string
+	QuarantineToDN(uid string) string
 
 	// Basic LDAP operations
 	EntryExists(dn string) (bool, error)
 	CreateEntry(dn string, attrs map[string][]string) error
 	ModifyEntry(dn string, attrs map[string][]string, modType int) error
+	ModifyEntryAttributes(dn string, changes []AttributeChange) error
 	DeleteEntry(dn string) error
+	MoveEntry(dn string, newSuperior string) error
 	GetExistingEntries(ou string, entryType string) (map[string]string, error)
+	GetEntryAttributes(dn string) (map[string][]string, error)
+
+	// Attribute generation
+	GetPersonAttributes(person *model.Person) map[string][]string
+	GetSvcAcctAttributes(svcacct *model.SvcAcct) map[string][]string
+	GetGroupAttributes(groupname string, group *model.Group) (map[string][]string, error)
 
 	// OU management
 	EnsureManagedOUsExist() error
@@ -40,6 +49,13 @@ type LDAPClientInterface interface {
 	SyncSvcAcct(uid string, svcacct *model.SvcAcct) error
 	SyncGroup(groupname string, group *model.Group) error
 	SyncAll() error
+	PlanSync() (*SyncPlan, error)
+	PrepareConfig() error
+	ApplyPlan(plan *SyncPlan) error
+
+	// Attributes owned by the enforcer for an entity type
+	managedAttributes(entityType string) []string
+	setWrittenState(state *posixState)
 
 	// Dependency resolution for internal implementation
 	getGroupDependencies(groupname string, processedGroups map[string]bool) ([]string, []string)
@@ -49,6 +65,9 @@ type LDAPClientInterface interface {
 // BaseClient implements shared functionality across real and mock clients
 type BaseClient struct {
 	config *config.Config
+
+	// The attribute names and account locks that earlier syncs recorded in the state file
+	written *posixState
 }
 
 // PersonToDN converts a person UID to a DN
@@ -65,15 +84,22 @@ func (b *BaseClient) SvcAcctToDN(uid string) string {
 		b.config.LDAPEnforcer.EnforcedSvcAcctOU)
 }
 
-// GroupToDN converts a group name to a DN
+// GroupToDN converts a group name to a DN.
+// User private groups are in the private group OU.
 func (b *BaseClient) GroupToDN(groupname string) string {
+	ou := b.config.LDAPEnforcer.EnforcedGroupOU
+	if group, ok := b.config.LDAPEnforcer.Group[groupname]; ok && group.Private {
+		ou = b.config.GetPrivateGroupOU()
+	}
 	return fmt.Sprintf("cn=%s,%s",
 		ldap.EscapeFilter(groupname),
-		b.config.LDAPEnforcer.EnforcedGroupOU)
+		ou)
 }
 
 // getGroupDependencies returns a list of groups that this group depends on
-// and a list of unresolvable member UIDs
+// and a list of unresolvable member UIDs.
+// A group only depends on the nested groups it references in member,
+// which must be created first; flattened nested groups are not dependencies.
 func (b *BaseClient) getGroupDependencies(groupname string, processedGroups map[string]bool) ([]string, []string) {
 	// Mark this group as visited to avoid infinite recursion
 	processedGroups[groupname] = true
@@ -103,14 +129,19 @@ func (b *BaseClient) getGroupDependencies(groupname string, processedGroups map[
 	}
 
 	// Check group members and recursively build dependencies
+	references := b.config.GetGroupNesting(group) != model.NestingFlatten
 	for _, nestedGroupName := range group.Groups {
 		// Add as dependency
-		dependencies = append(dependencies, nestedGroupName)
+		if references {
+			dependencies = append(dependencies, nestedGroupName)
+		}
 
 		// If we haven't processed this nested group yet, get its dependencies too
 		if !processedGroups[nestedGroupName] {
 			nestedDeps, nestedUnres := b.getGroupDependencies(nestedGroupName, processedGroups)
-			dependencies = append(dependencies, nestedDeps...)
+			if references {
+				dependencies = append(dependencies, nestedDeps...)
+			}
 			unresolvableMembers = append(unresolvableMembers, nestedUnres...)
 		}
 	}
@@ -181,11 +212,7 @@ func (b *BaseClient) topologicalSortGroups(deps map[string][]string) []string {
 		}
 	}
 
-	// Reverse the order to get dependencies first
-	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
-		order[i], order[j] = order[j], order[i]
-	}
-
+	// Each group is appended after its dependencies, so the order already has dependencies first
 	return order
 }
 
@@ -325,14 +352,17 @@ func (c *Client) Search(baseDN, filter string, attributes []string) (*ldap.Searc
 		return nil, fmt.Errorf("LDAP search failed: %w", err)
 	}
 
-	logging.LDAPProtocolLogger.Trace("LDAP Search complete (found %d entries): %+v", len(result.Entries), result)
+	logging.LDAPProtocolLogger.Trace("LDAP Search complete (found %d entries)", len(result.Entries))
+	for _, entry := range result.Entries {
+		logging.LDAPProtocolLogger.Trace("LDAP Search result %s: %+v", entry.DN, redactAttributes(entryAttributes(entry)))
+	}
 
 	return result, nil
 }
 
 // CreateEntry creates a new LDAP entry
 func (c *Client) CreateEntry(dn string, attributes map[string][]string) error {
-	logging.LDAPProtocolLogger.Trace("Creating LDAP entry: %+v", attributes)
+	logging.LDAPProtocolLogger.Trace("Creating LDAP entry: %+v", redactAttributes(attributes))
 
 	// Convert map to ldap.AddRequest
 	addReq := ldap.NewAddRequest(dn, nil)
@@ -354,20 +384,20 @@ func (c *Client) CreateEntry(dn string, attributes map[string][]string) error {
 // ModifyEntry modifies an existing LDAP entry
 func (c *Client) ModifyEntry(dn string, mods map[string][]string, operation int) error {
 	// Get operation name for logging
-	logging.LDAPProtocolLogger.Trace("Modifying LDAP entry: %+v", mods)
+	logging.LDAPProtocolLogger.Trace("Modifying LDAP entry: %+v", redactAttributes(mods))
 
 	// Convert map to ldap.ModifyRequest
 	modReq := ldap.NewModifyRequest(dn, nil)
 	for attr, values := range mods {
 		switch operation {
 		case ldap.AddAttribute:
-			logging.LDAPProtocolLogger.Trace("Adding attribute %s with values: %v", attr, values)
+			logging.LDAPProtocolLogger.Trace("Adding attribute %s with values: %v", attr, RedactValues(attr, values))
 			modReq.Add(attr, values)
 		case ldap.ReplaceAttribute:
-			logging.LDAPProtocolLogger.Trace("Replacing attribute %s with values: %v", attr, values)
+			logging.LDAPProtocolLogger.Trace("Replacing attribute %s with values: %v", attr, RedactValues(attr, values))
 			modReq.Replace(attr, values)
 		case ldap.DeleteAttribute:
-			logging.LDAPProtocolLogger.Trace("Deleting attribute %s with values: %v", attr, values)
+			logging.LDAPProtocolLogger.Trace("Deleting attribute %s with values: %v", attr, RedactValues(attr, values))
 			modReq.Delete(attr, values)
 		default:
 			logging.LDAPProtocolLogger.Error("Invalid LDAP modify operation: %d", operation)
@@ -376,7 +406,7 @@ func (c *Client) ModifyEntry(dn string, mods map[string][]string, operation int)
 	}
 
 	// Execute modify request
-	logging.LDAPProtocolLogger.Trace("Sending LDAP Modify request: %+v", modReq)
+	logging.LDAPProtocolLogger.Trace("Sending LDAP Modify request for DN=%s with %d changes", dn, len(modReq.Changes))
 	err := c.conn.Modify(modReq)
 	if err != nil {
 		logging.LDAPProtocolLogger.Error("Failed to modify LDAP entry: %v", err)
@@ -387,6 +417,51 @@ func (c *Client) ModifyEntry(dn string, mods map[string][]string, operation int)
 	return nil
 }
 
+// ModifyEntryAttributes applies a set of attribute changes in a single modify request.
+// Attributes with new values are replaced and attributes without new values are deleted,
+// so that related changes (like removing posixAccount along with uidNumber) are atomic.
+func (c *Client) ModifyEntryAttributes(dn string, changes []AttributeChange) error {
+	logging.LDAPProtocolLogger.Trace("Modifying LDAP entry attributes: %+v", RedactAttributeChanges(changes))
+
+	modReq := ldap.NewModifyRequest(dn, nil)
+	for _, change := range changes {
+		if len(change.New) > 0 {
+			logging.LDAPProtocolLogger.Trace("Replacing attribute %s with values: %v", change.Name, RedactValues(change.Name, change.New))
+			modReq.Replace(change.Name, change.New)
+		} else {
+			logging.LDAPProtocolLogger.Trace("Deleting attribute %s", change.Name)
+			modReq.Delete(change.Name, nil)
+		}
+	}
+
+	// Execute modify request
+	logging.LDAPProtocolLogger.Trace("Sending LDAP Modify request for DN=%s with %d changes", dn, len(modReq.Changes))
+	err := c.conn.Modify(modReq)
+	if err != nil {
+		logging.LDAPProtocolLogger.Error("Failed to modify LDAP entry: %v", err)
+		return fmt.Errorf("failed to modify LDAP entry: %w", err)
+	}
+
+	logging.LDAPProtocolLogger.Trace("Successfully modified LDAP entry: %s", dn)
+	return nil
+}
+
+// MoveEntry moves an LDAP entry to a new parent, keeping its RDN
+func (c *Client) MoveEntry(dn string, newSuperior string) error {
+	logging.LDAPProtocolLogger.Trace("Sending LDAP ModifyDN operation for DN=%s to %s", dn, newSuperior)
+
+	rdn, _ := splitDN(dn)
+	modDNReq := ldap.NewModifyDNRequest(dn, rdn, true, newSuperior)
+	err := c.conn.ModifyDN(modDNReq)
+	if err != nil {
+		logging.LDAPProtocolLogger.Error("Failed to move LDAP entry: %v", err)
+		return fmt.Errorf("failed to move LDAP entry: %w", err)
+	}
+
+	logging.LDAPProtocolLogger.Info("Successfully moved LDAP entry %s to %s", dn, newSuperior)
+	return nil
+}
+
 // DeleteEntry deletes an LDAP entry
 func (c *Client) DeleteEntry(dn string) error {
 	logging.LDAPProtocolLogger.Trace("Sending LDAP delete operation for DN=%s", dn)
@@ -402,6 +477,44 @@ func (c *Client) DeleteEntry(dn string) error {
 	return nil
 }
 
+// GetEntryAttributes retrieves all user attributes of an entry, plus nsAccountLock, as a map.
+// It returns a nil map and no error if the entry does not exist.
+func (c *Client) GetEntryAttributes(dn string) (map[string][]string, error) {
+	logging.LDAPProtocolLogger.Trace("LDAP attribute fetch - DN: %s", dn)
+
+	searchRequest := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, // No size limit
+		0, // No time limit
+		false,
+		"(objectClass=*)",
+		// nsAccountLock is operational in 389 DS and not returned by "*"
+		[]string{"*", "nsAccountLock"},
+		nil,
+	)
+
+	result, err := c.conn.Search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			logging.LDAPProtocolLogger.Debug("LDAP entry does not exist: %s", dn)
+			return nil, nil
+		}
+		logging.LDAPProtocolLogger.Error("LDAP attribute fetch failed: %v", err)
+		return nil, fmt.Errorf("LDAP search failed: %w", err)
+	}
+
+	if len(result.Entries) == 0 {
+		return nil, nil
+	}
+
+	attrs := entryAttributes(result.Entries[0])
+
+	logging.LDAPProtocolLogger.Trace("Successfully retrieved LDAP attributes: %+v", redactAttributes(attrs))
+	return attrs, nil
+}
+
 // GetEntity retrieves an entity from LDAP
 func (c *Client) GetEntity(dn string, attributes []string) (*ldap.Entry, error) {
 	logging.LDAPProtocolLogger.Trace("LDAP entity fetch - DN: %s, Attributes: %v", dn, attributes)
@@ -429,7 +542,7 @@ func (c *Client) GetEntity(dn string, attributes []string) (*ldap.Entry, error)
 		return nil, fmt.Errorf("no entry found for DN: %s", dn)
 	}
 
-	logging.LDAPProtocolLogger.Trace("Successfully retrieved LDAP entity: %+v", result.Entries[0])
+	logging.LDAPProtocolLogger.Trace("Successfully retrieved LDAP entity %s: %+v", dn, redactAttributes(entryAttributes(result.Entries[0])))
 	return result.Entries[0], nil
 }
 
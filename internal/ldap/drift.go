@@ -0,0 +1,140 @@
+package ldap
+
+import (
+	"strings"
+	"time"
+)
+
+// DriftStatus describes how an entry in LDAP compares to the configuration
+type DriftStatus string
+
+// Drift statuses
+const (
+	// DriftOK means the entry matches the configuration
+	DriftOK DriftStatus = "ok"
+	// DriftMissing means the entry is in the configuration but not in the enforced OU
+	DriftMissing DriftStatus = "missing"
+	// DriftChanged means the entry exists but some attributes differ from the configuration
+	DriftChanged DriftStatus = "changed"
+	// DriftExtra means the entry is in an enforced OU but should not exist according to the configuration
+	DriftExtra DriftStatus = "extra"
+)
+
+// DriftEntry describes how a single entry compares to the configuration
+type DriftEntry struct {
+	// Status of the entry
+	Status DriftStatus `json:"status"`
+
+	// EntityType is the type of entity (person, svcacct, group)
+	EntityType string `json:"entity_type"`
+
+	// ID is the uid or group name of the entity
+	ID string `json:"id"`
+
+	// DN is the distinguished name the entry has or should have
+	DN string `json:"dn"`
+
+	// Attributes lists the attributes that differ, with the LDAP values as Old and the configured values as New
+	Attributes []AttributeChange `json:"attributes,omitempty"`
+}
+
+// DriftReport compares every managed entry to the configuration
+type DriftReport struct {
+	// CheckedAt is when the directory was compared to the configuration
+	CheckedAt time.Time `json:"checked_at"`
+
+	// Entries for every configured entity and every extra entry in the enforced OUs
+	Entries []*DriftEntry `json:"entries"`
+}
+
+// NewDriftReport builds a drift report from a sync plan: anything a sync would change is drift.
+// Purges of quarantined entries are not drift, because the quarantine OU is not enforced.
+func NewDriftReport(plan *SyncPlan) *DriftReport {
+	report := &DriftReport{
+		CheckedAt: plan.CreatedAt,
+		Entries:   []*DriftEntry{},
+	}
+
+	for _, change := range plan.Changes {
+		entry := &DriftEntry{
+			EntityType: change.EntityType,
+			ID:         change.ID,
+			DN:         change.DN,
+		}
+
+		switch change.Action {
+		case ChangeUnchanged:
+			entry.Status = DriftOK
+		case ChangeCreate:
+			entry.Status = DriftMissing
+		case ChangeRestore:
+			// The entry is still in the quarantine OU
+			entry.Status = DriftMissing
+			entry.DN = change.TargetDN()
+		case ChangeModify:
+			entry.Status = DriftChanged
+			entry.Attributes = RedactAttributeChanges(change.Attributes)
+		case ChangeDelete, ChangeQuarantine:
+			entry.Status = DriftExtra
+		default:
+			continue
+		}
+
+		report.Entries = append(report.Entries, entry)
+	}
+
+	return report
+}
+
+// Count returns the number of entries with the given status
+func (r *DriftReport) Count(status DriftStatus) int {
+	count := 0
+	for _, entry := range r.Entries {
+		if entry.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// HasDrift returns true if any entry differs from the configuration
+func (r *DriftReport) HasDrift() bool {
+	return r.Count(DriftOK) != len(r.Entries)
+}
+
+// HideValue removes a value of an attribute from the attribute changes of one entity in the report,
+// such as the placeholder member of a group that allows being empty.
+// Changes that no longer differ are dropped, and an entry left without changes is OK.
+func (r *DriftReport) HideValue(entityType, id, attrName, value string) {
+	for _, entry := range r.Entries {
+		if entry.Status != DriftChanged || entry.EntityType != entityType || entry.ID != id {
+			continue
+		}
+		var kept []AttributeChange
+		for _, change := range entry.Attributes {
+			if strings.EqualFold(change.Name, attrName) {
+				change.Old = withoutValue(change.Old, value)
+				change.New = withoutValue(change.New, value)
+				if sameValues(change.Old, change.New) {
+					continue
+				}
+			}
+			kept = append(kept, change)
+		}
+		entry.Attributes = kept
+		if len(kept) == 0 {
+			entry.Status = DriftOK
+		}
+	}
+}
+
+// withoutValue returns values with every case-insensitive match of value removed
+func withoutValue(values []string, value string) []string {
+	var result []string
+	for _, v := range values {
+		if !strings.EqualFold(v, value) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
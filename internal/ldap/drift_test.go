@@ -0,0 +1,145 @@
+package ldap
+
+import (
+	"testing"
+
+	"github.com/mrled/ldapenforcer/internal/model"
+)
+
+func TestNewDriftReport(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	mockClient := NewMockClient(testConfig)
+
+	sameDN := mockClient.PersonToDN("sameuser")
+	mockClient.Existing[sameDN] = true
+	mockClient.Entries[sameDN] = mockClient.GetPersonAttributes(&model.Person{Username: "sameuser", CN: "Same User"})
+
+	modDN := mockClient.PersonToDN("moduser")
+	mockClient.Existing[modDN] = true
+	mockClient.Entries[modDN] = mockClient.GetPersonAttributes(&model.Person{Username: "moduser", CN: "Modified User", Mail: "old@example.com"})
+
+	oldDN := mockClient.PersonToDN("olduser")
+	mockClient.Existing[oldDN] = true
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	report := NewDriftReport(plan)
+
+	statuses := make(map[string]DriftStatus)
+	for _, entry := range report.Entries {
+		statuses[entry.DN] = entry.Status
+	}
+	expected := map[string]DriftStatus{
+		sameDN:                            DriftOK,
+		modDN:                             DriftChanged,
+		oldDN:                             DriftExtra,
+		mockClient.PersonToDN("newuser"):  DriftMissing,
+		mockClient.GroupToDN("testgroup"): DriftMissing,
+	}
+	for dn, status := range expected {
+		if statuses[dn] != status {
+			t.Errorf("Expected %s for %s, got %q", status, dn, statuses[dn])
+		}
+	}
+	if !report.HasDrift() {
+		t.Error("Expected the report to have drift")
+	}
+
+	// After a sync there is nothing left to report
+	if err := mockClient.ApplyPlan(plan); err != nil {
+		t.Fatalf("ApplyPlan failed: %v", err)
+	}
+	plan, err = mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if report := NewDriftReport(plan); report.HasDrift() {
+		t.Errorf("Expected no drift after applying the plan, got %+v", report.Entries)
+	}
+}
+
+func TestNewDriftReportGroupMembers(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	mockClient := NewMockClient(testConfig)
+
+	// The group exists but is missing one of its configured members
+	groupDN := mockClient.GroupToDN("testgroup")
+	mockClient.Existing[groupDN] = true
+	mockClient.Entries[groupDN] = map[string][]string{
+		"objectClass": {"top", "groupOfNames"},
+		"cn":          {"testgroup"},
+		"description": {"Test Group"},
+		"member":      {mockClient.PersonToDN("newuser")},
+	}
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	for _, entry := range NewDriftReport(plan).Entries {
+		if entry.DN != groupDN {
+			continue
+		}
+		if entry.Status != DriftChanged {
+			t.Fatalf("Expected testgroup to have changed, got %s", entry.Status)
+		}
+		if len(entry.Attributes) != 1 || entry.Attributes[0].Name != "member" {
+			t.Errorf("Expected only member to differ, got %+v", entry.Attributes)
+		}
+		return
+	}
+	t.Error("Expected testgroup in the drift report")
+}
+
+func TestDriftReportHideValue(t *testing.T) {
+	placeholder := "cn=nobody"
+	report := &DriftReport{Entries: []*DriftEntry{
+		{
+			// Only the placeholder differs, so the group is OK
+			Status:     DriftChanged,
+			EntityType: "group",
+			ID:         "placeholder-only",
+			Attributes: []AttributeChange{
+				{Name: "member", New: []string{placeholder}},
+			},
+		},
+		{
+			// The placeholder is being replaced by a real member
+			Status:     DriftChanged,
+			EntityType: "group",
+			ID:         "real-member",
+			Attributes: []AttributeChange{
+				{Name: "member", Old: []string{"CN=Nobody"}, New: []string{"uid=john,ou=people,dc=example,dc=com"}},
+			},
+		},
+		{
+			// The placeholder leaked into a group whose value is not hidden
+			Status:     DriftChanged,
+			EntityType: "group",
+			ID:         "not-allow-empty",
+			Attributes: []AttributeChange{
+				{Name: "member", Old: []string{placeholder}},
+			},
+		},
+	}}
+
+	report.HideValue("group", "placeholder-only", "member", placeholder)
+	report.HideValue("group", "real-member", "member", placeholder)
+
+	if entry := report.Entries[0]; entry.Status != DriftOK || len(entry.Attributes) != 0 {
+		t.Errorf("Expected placeholder-only to be OK, got %s with %+v", entry.Status, entry.Attributes)
+	}
+	entry := report.Entries[1]
+	if entry.Status != DriftChanged || len(entry.Attributes) != 1 {
+		t.Fatalf("Expected real-member to have changed, got %s with %+v", entry.Status, entry.Attributes)
+	}
+	if len(entry.Attributes[0].Old) != 0 || len(entry.Attributes[0].New) != 1 {
+		t.Errorf("Expected the placeholder to be hidden, got %+v", entry.Attributes[0])
+	}
+	if entry := report.Entries[2]; entry.Status != DriftChanged || len(entry.Attributes) != 1 {
+		t.Errorf("Expected not-allow-empty to keep the placeholder as drift, got %s with %+v", entry.Status, entry.Attributes)
+	}
+}
@@ -0,0 +1,157 @@
+package ldap
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ldifLineLength is the maximum line length before an LDIF line is folded
+const ldifLineLength = 76
+
+// WriteLDIF writes the plan as RFC 2849 change records that can be applied with ldapmodify.
+// Missing managed OUs are added first, so that the entry records can be applied to a fresh directory.
+// Unchanged entries are omitted.
+func (p *SyncPlan) WriteLDIF(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	writeLDIFLine(bw, "version: 1")
+
+	for _, ou := range p.MissingOUs {
+		bw.WriteString("\n")
+		writeLDIFAttribute(bw, "dn", ou)
+		writeLDIFLine(bw, "changetype: add")
+		writeLDIFLine(bw, "objectClass: top")
+		writeLDIFLine(bw, "objectClass: organizationalUnit")
+		writeLDIFAttribute(bw, "ou", getOUFromDN(ou))
+	}
+
+	for _, change := range p.Changes {
+		if change.Action == ChangeUnchanged {
+			continue
+		}
+
+		bw.WriteString("\n")
+		writeLDIFAttribute(bw, "dn", change.DN)
+
+		switch change.Action {
+		case ChangeCreate:
+			writeLDIFLine(bw, "changetype: add")
+			for _, attr := range change.Attributes {
+				for _, value := range attr.New {
+					writeLDIFAttribute(bw, attr.Name, value)
+				}
+			}
+
+		case ChangeModify:
+			writeLDIFLine(bw, "changetype: modify")
+			writeLDIFModifications(bw, change.Attributes)
+
+		case ChangeDelete, ChangePurge:
+			writeLDIFLine(bw, "changetype: delete")
+
+		case ChangeQuarantine, ChangeRestore:
+			rdn, _ := splitDN(change.DN)
+			writeLDIFLine(bw, "changetype: modrdn")
+			writeLDIFAttribute(bw, "newrdn", rdn)
+			writeLDIFLine(bw, "deleteoldrdn: 1")
+			writeLDIFAttribute(bw, "newsuperior", change.NewSuperior)
+			if len(change.Attributes) > 0 {
+				bw.WriteString("\n")
+				writeLDIFAttribute(bw, "dn", change.TargetDN())
+				writeLDIFLine(bw, "changetype: modify")
+				writeLDIFModifications(bw, change.Attributes)
+			}
+
+		default:
+			return fmt.Errorf("unknown change action: %s", change.Action)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// WriteLDIFFile writes the plan as LDIF to a file, or to stdout if path is "-"
+func (p *SyncPlan) WriteLDIFFile(path string) error {
+	if path == "-" {
+		return p.WriteLDIF(os.Stdout)
+	}
+
+	// The LDIF can contain password hashes, so only the owner may read it
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create LDIF file %s: %w", path, err)
+	}
+
+	err = p.WriteLDIF(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write LDIF file %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeLDIFModifications writes the body of a modify record, replacing or deleting each attribute
+func writeLDIFModifications(w *bufio.Writer, attrs []AttributeChange) {
+	for _, attr := range attrs {
+		if len(attr.New) > 0 {
+			writeLDIFLine(w, "replace: "+attr.Name)
+			for _, value := range attr.New {
+				writeLDIFAttribute(w, attr.Name, value)
+			}
+		} else {
+			writeLDIFLine(w, "delete: "+attr.Name)
+		}
+		writeLDIFLine(w, "-")
+	}
+}
+
+// writeLDIFAttribute writes an "attr: value" line, base64-encoding values that are not SAFE-STRINGs
+func writeLDIFAttribute(w *bufio.Writer, name, value string) {
+	if isLDIFSafeString(value) {
+		writeLDIFLine(w, name+": "+value)
+	} else {
+		writeLDIFLine(w, name+":: "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+}
+
+// writeLDIFLine writes a line, folding it onto continuation lines that begin with a space
+func writeLDIFLine(w *bufio.Writer, line string) {
+	width := ldifLineLength
+	for len(line) > width {
+		w.WriteString(line[:width])
+		w.WriteString("\n ")
+		line = line[width:]
+		// Continuation lines lose one character to the leading space
+		width = ldifLineLength - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\n")
+}
+
+// isLDIFSafeString reports whether a value can be written as-is according to RFC 2849:
+// printable ASCII without NUL, CR, or LF, not starting with a space, colon, or less-than sign,
+// and (by convention) not ending with a space
+func isLDIFSafeString(value string) bool {
+	if value == "" {
+		return true
+	}
+	switch value[0] {
+	case ' ', ':', '<':
+		return false
+	}
+	if value[len(value)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == 0 || c == '\n' || c == '\r' || c > 127 {
+			return false
+		}
+	}
+	return true
+}
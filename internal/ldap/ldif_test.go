@@ -0,0 +1,209 @@
+package ldap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteLDIF(t *testing.T) {
+	plan := &SyncPlan{
+		Changes: []*EntityChange{
+			{
+				Action: ChangeCreate,
+				DN:     "uid=john,ou=people,dc=example,dc=com",
+				Attributes: []AttributeChange{
+					{Name: "cn", New: []string{"John Doe"}},
+					{Name: "objectClass", New: []string{"top", "inetOrgPerson"}},
+					{Name: "sn", New: []string{"Düsseldorf"}},
+				},
+			},
+			{
+				Action: ChangeUnchanged,
+				DN:     "uid=jane,ou=people,dc=example,dc=com",
+			},
+			{
+				Action: ChangeModify,
+				DN:     "cn=admins,ou=groups,dc=example,dc=com",
+				Attributes: []AttributeChange{
+					{Name: "member", Old: []string{"uid=old,ou=people,dc=example,dc=com"}, New: []string{"uid=john,ou=people,dc=example,dc=com"}},
+					{Name: "description", Old: []string{"Old"}},
+				},
+			},
+			{
+				Action: ChangeDelete,
+				DN:     "uid=old,ou=people,dc=example,dc=com",
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := plan.WriteLDIF(&buf); err != nil {
+		t.Fatalf("WriteLDIF failed: %v", err)
+	}
+
+	expected := `version: 1
+
+dn: uid=john,ou=people,dc=example,dc=com
+changetype: add
+cn: John Doe
+objectClass: top
+objectClass: inetOrgPerson
+sn:: RMO8c3NlbGRvcmY=
+
+dn: cn=admins,ou=groups,dc=example,dc=com
+changetype: modify
+replace: member
+member: uid=john,ou=people,dc=example,dc=com
+-
+delete: description
+-
+
+dn: uid=old,ou=people,dc=example,dc=com
+changetype: delete
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected LDIF output.\nGot:\n%s\nExpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestWriteLDIFFoldsLongLines(t *testing.T) {
+	long := "cn=" + string(bytes.Repeat([]byte("a"), 100)) + ",ou=groups,dc=example,dc=com"
+	plan := &SyncPlan{
+		Changes: []*EntityChange{{Action: ChangeDelete, DN: long}},
+	}
+
+	var buf bytes.Buffer
+	if err := plan.WriteLDIF(&buf); err != nil {
+		t.Fatalf("WriteLDIF failed: %v", err)
+	}
+
+	for _, line := range bytes.Split(buf.Bytes(), []byte("\n")) {
+		if len(line) > ldifLineLength {
+			t.Errorf("Line longer than %d characters: %q", ldifLineLength, line)
+		}
+	}
+
+	// Unfolding the continuation lines should give back the original DN
+	unfolded := bytes.ReplaceAll(buf.Bytes(), []byte("\n "), nil)
+	if !bytes.Contains(unfolded, []byte("dn: "+long+"\n")) {
+		t.Errorf("Folded DN did not unfold to the original: %s", unfolded)
+	}
+}
+
+func TestWriteLDIFQuarantine(t *testing.T) {
+	plan := &SyncPlan{
+		Changes: []*EntityChange{
+			{
+				Action:      ChangeQuarantine,
+				DN:          "uid=old,ou=people,dc=example,dc=com",
+				NewSuperior: "ou=quarantine,dc=example,dc=com",
+				Attributes: []AttributeChange{
+					{Name: "nsAccountLock", New: []string{"TRUE"}},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := plan.WriteLDIF(&buf); err != nil {
+		t.Fatalf("WriteLDIF failed: %v", err)
+	}
+
+	expected := `version: 1
+
+dn: uid=old,ou=people,dc=example,dc=com
+changetype: modrdn
+newrdn: uid=old
+deleteoldrdn: 1
+newsuperior: ou=quarantine,dc=example,dc=com
+
+dn: uid=old,ou=quarantine,dc=example,dc=com
+changetype: modify
+replace: nsAccountLock
+nsAccountLock: TRUE
+-
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected LDIF output.\nGot:\n%s\nExpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestWriteLDIFFileMode(t *testing.T) {
+	plan := &SyncPlan{}
+	path := filepath.Join(t.TempDir(), "changes.ldif")
+	if err := plan.WriteLDIFFile(path); err != nil {
+		t.Fatalf("WriteLDIFFile failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("Expected the LDIF file to be created with mode 0600, got %o", mode)
+	}
+}
+
+func TestWriteLDIFMissingOUs(t *testing.T) {
+	plan := &SyncPlan{
+		MissingOUs: []string{"ou=people,dc=example,dc=com"},
+		Changes: []*EntityChange{
+			{
+				Action: ChangeCreate,
+				DN:     "uid=john,ou=people,dc=example,dc=com",
+				Attributes: []AttributeChange{
+					{Name: "cn", New: []string{"John Doe"}},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := plan.WriteLDIF(&buf); err != nil {
+		t.Fatalf("WriteLDIF failed: %v", err)
+	}
+
+	expected := `version: 1
+
+dn: ou=people,dc=example,dc=com
+changetype: add
+objectClass: top
+objectClass: organizationalUnit
+ou: people
+
+dn: uid=john,ou=people,dc=example,dc=com
+changetype: add
+cn: John Doe
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected LDIF output.\nGot:\n%s\nExpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestPlanSyncMissingOUs(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	testConfig.LDAPEnforcer.QuarantineOU = "ou=quarantine,dc=example,dc=com"
+	mockClient := NewMockClient(testConfig)
+	mockClient.Existing[testConfig.LDAPEnforcer.EnforcedPeopleOU] = true
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	expected := []string{
+		testConfig.LDAPEnforcer.EnforcedSvcAcctOU,
+		testConfig.LDAPEnforcer.EnforcedGroupOU,
+		testConfig.LDAPEnforcer.QuarantineOU,
+	}
+	if len(plan.MissingOUs) != len(expected) {
+		t.Fatalf("Expected missing OUs %v, got %v", expected, plan.MissingOUs)
+	}
+	for i, ou := range expected {
+		if plan.MissingOUs[i] != ou {
+			t.Errorf("Expected missing OU %s at position %d, got %s", ou, i, plan.MissingOUs[i])
+		}
+	}
+}
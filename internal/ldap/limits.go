@@ -0,0 +1,85 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mrled/ldapenforcer/internal/config"
+	"github.com/mrled/ldapenforcer/internal/logging"
+)
+
+// checkDeletionLimits refuses a sync that would delete more entries than the configured limits allow.
+// This protects against a broken include or truncated config file deleting the whole directory.
+// existingCount is the number of entries currently in the enforced OUs.
+func checkDeletionLimits(cfg *config.Config, deletions []string, existingCount int) error {
+	if len(deletions) == 0 {
+		return nil
+	}
+
+	maxDeletions := cfg.LDAPEnforcer.MaxDeletions
+	maxPercent := cfg.LDAPEnforcer.MaxDeletionPercent
+
+	var reason string
+	if maxDeletions > 0 && len(deletions) > maxDeletions {
+		reason = fmt.Sprintf("%d deletions exceeds max_deletions of %d", len(deletions), maxDeletions)
+	} else if maxPercent > 0 && existingCount > 0 && len(deletions)*100 > maxPercent*existingCount {
+		reason = fmt.Sprintf("%d deletions out of %d existing entries exceeds max_deletion_percent of %d%%",
+			len(deletions), existingCount, maxPercent)
+	}
+	if reason == "" {
+		return nil
+	}
+
+	if cfg.LDAPEnforcer.AllowMassDelete {
+		logging.DefaultLogger.Warn("Deletion limit exceeded (%s), continuing because mass deletion is allowed", reason)
+		return nil
+	}
+
+	for _, dn := range deletions {
+		logging.DefaultLogger.Error("Would have deleted: %s", dn)
+	}
+	return fmt.Errorf("refusing to sync: %s; no changes were made (use --allow-mass-delete to override)", reason)
+}
+
+// deletions returns the DNs of all entries the classification would delete or quarantine,
+// including existing groups that have no members left and would be deleted by planGroup
+func (cls *syncClassification) deletions(c LDAPClientInterface) []string {
+	var dns []string
+	dns = append(dns, sortedKeys(cls.groupsToDelete)...)
+	for _, groupname := range sortedKeys(cls.groupsToModify) {
+		_, err := c.GetGroupAttributes(groupname, cls.groupsToModify[groupname])
+		if errors.Is(err, ErrGroupHasNoMembers) {
+			dns = append(dns, c.GroupToDN(groupname))
+		}
+	}
+	dns = append(dns, sortedKeys(cls.peopleToDelete)...)
+	dns = append(dns, sortedKeys(cls.svcAcctsToDelete)...)
+	return dns
+}
+
+// deletions returns the DNs of all entries the plan would delete or quarantine.
+// Purges of quarantined entries are not counted because they were already removed from the config.
+func (p *SyncPlan) deletions() []string {
+	var dns []string
+	for _, change := range p.Changes {
+		if change.Action == ChangeDelete || change.Action == ChangeQuarantine {
+			dns = append(dns, change.DN)
+		}
+	}
+	return dns
+}
+
+// existingCount returns the number of entries that existed in the enforced OUs when the plan was made
+func (p *SyncPlan) existingCount() int {
+	count := 0
+	for _, change := range p.Changes {
+		// Restored and purged entries live in the quarantine OU
+		if change.Action == ChangeRestore || change.Action == ChangePurge {
+			continue
+		}
+		if change.Fingerprint != "" {
+			count++
+		}
+	}
+	return count
+}
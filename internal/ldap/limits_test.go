@@ -0,0 +1,102 @@
+package ldap
+
+import (
+	"testing"
+
+	"github.com/mrled/ldapenforcer/internal/config"
+	"github.com/mrled/ldapenforcer/internal/model"
+)
+
+func TestDeletionLimits(t *testing.T) {
+	newClient := func(maxDeletions, maxPercent int, allow bool) *MockClient {
+		testConfig := &config.Config{
+			LDAPEnforcer: config.LDAPEnforcerConfig{
+				EnforcedPeopleOU:   "ou=people,dc=example,dc=com",
+				EnforcedSvcAcctOU:  "ou=svcaccts,dc=example,dc=com",
+				EnforcedGroupOU:    "ou=groups,dc=example,dc=com",
+				MaxDeletions:       maxDeletions,
+				MaxDeletionPercent: maxPercent,
+				AllowMassDelete:    allow,
+				Person: map[string]*model.Person{
+					"keep": {CN: "Keep Me"},
+				},
+			},
+		}
+		mockClient := NewMockClient(testConfig)
+
+		// One person stays, three are no longer in the config
+		for _, uid := range []string{"keep", "gone1", "gone2", "gone3"} {
+			mockClient.Existing[mockClient.PersonToDN(uid)] = true
+		}
+		return mockClient
+	}
+
+	tests := []struct {
+		name         string
+		maxDeletions int
+		maxPercent   int
+		allow        bool
+		expectError  bool
+	}{
+		{"No limits", 0, 0, false, false},
+		{"Under max_deletions", 3, 0, false, false},
+		{"Over max_deletions", 2, 0, false, true},
+		{"Under max_deletion_percent", 0, 75, false, false},
+		{"Over max_deletion_percent", 0, 50, false, true},
+		{"Over limits with override", 2, 50, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := newClient(tt.maxDeletions, tt.maxPercent, tt.allow)
+			err := mockClient.SyncAll()
+
+			if tt.expectError {
+				if err == nil {
+					t.Fatal("Expected the sync to be refused")
+				}
+				if len(mockClient.Operations) != 0 {
+					t.Errorf("Expected no operations when the limit is exceeded, got %+v", mockClient.Operations)
+				}
+			} else if err != nil {
+				t.Fatalf("Did not expect an error but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeletionLimitsCountMemberlessGroups(t *testing.T) {
+	testConfig := &config.Config{
+		LDAPEnforcer: config.LDAPEnforcerConfig{
+			EnforcedPeopleOU:  "ou=people,dc=example,dc=com",
+			EnforcedSvcAcctOU: "ou=svcaccts,dc=example,dc=com",
+			EnforcedGroupOU:   "ou=groups,dc=example,dc=com",
+			MaxDeletions:      2,
+			Person: map[string]*model.Person{
+				"keep": {CN: "Keep Me"},
+			},
+			Group: map[string]*model.Group{
+				"devs":   {People: []string{"gone1"}},
+				"admins": {People: []string{"gone2"}},
+			},
+		},
+	}
+	mockClient := NewMockClient(testConfig)
+
+	// A truncated people list leaves both existing groups without members
+	for _, uid := range []string{"keep", "gone1"} {
+		mockClient.Existing[mockClient.PersonToDN(uid)] = true
+	}
+	for _, groupname := range []string{"devs", "admins"} {
+		mockClient.Existing[mockClient.GroupToDN(groupname)] = true
+	}
+
+	// One person and two groups would be deleted
+	err := mockClient.SyncAll()
+	if err == nil {
+		t.Fatal("Expected the sync to be refused")
+	}
+	if len(mockClient.Operations) != 0 {
+		t.Errorf("Expected no operations when the limit is exceeded, got %+v", mockClient.Operations)
+	}
+}
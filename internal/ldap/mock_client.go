@@ -1,18 +1,16 @@
 package ldap
 
 import (
-	"fmt"
 	"strings"
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/mrled/ldapenforcer/internal/config"
-	"github.com/mrled/ldapenforcer/internal/logging"
 	"github.com/mrled/ldapenforcer/internal/model"
 )
 
 // MockOperation represents a mock LDAP operation for testing
 type MockOperation struct {
-	OpType   string // "create", "modify", "delete"
+	OpType   string // "create", "modify", "move", "delete"
 	DN       string
 	EntityID string
 	Type     string // "person", "svcacct", "group"
@@ -22,7 +20,9 @@ type MockOperation struct {
 type MockClient struct {
 	BaseClient
 	Operations []MockOperation
-	Existing   map[string]bool // map of DNs that "exist" in the mock LDAP server
+	Existing   map[string]bool                // map of DNs that "exist" in the mock LDAP server
+	Entries    map[string]map[string][]string // map of DNs to their attributes, for entries created with attributes
+	Failures   map[string]error               // map of DNs to the error any write to them should return
 }
 
 // NewMockClient creates a new mock LDAP client
@@ -33,6 +33,8 @@ func NewMockClient(cfg *config.Config) *MockClient {
 		},
 		Operations: []MockOperation{},
 		Existing:   make(map[string]bool),
+		Entries:    make(map[string]map[string][]string),
+		Failures:   make(map[string]error),
 	}
 }
 
@@ -48,6 +50,9 @@ func (m *MockClient) EntryExists(dn string) (bool, error) {
 
 // CreateEntry records a create operation and marks the entry as existing
 func (m *MockClient) CreateEntry(dn string, attrs map[string][]string) error {
+	if err := m.Failures[dn]; err != nil {
+		return err
+	}
 	entityType, entityID := getEntityTypeAndID(dn)
 
 	m.Operations = append(m.Operations, MockOperation{
@@ -57,11 +62,48 @@ func (m *MockClient) CreateEntry(dn string, attrs map[string][]string) error {
 		Type:     entityType,
 	})
 	m.Existing[dn] = true
+	m.Entries[dn] = copyAttributes(attrs)
 	return nil
 }
 
 // ModifyEntry records a modify operation
 func (m *MockClient) ModifyEntry(dn string, attrs map[string][]string, modType int) error {
+	if err := m.Failures[dn]; err != nil {
+		return err
+	}
+	entityType, entityID := getEntityTypeAndID(dn)
+
+	m.Operations = append(m.Operations, MockOperation{
+		OpType:   "modify",
+		DN:       dn,
+		EntityID: entityID,
+		Type:     entityType,
+	})
+
+	// Apply the modification to the stored attributes
+	entry := m.Entries[dn]
+	if entry == nil {
+		entry = make(map[string][]string)
+		m.Entries[dn] = entry
+	}
+	for attr, values := range attrs {
+		switch modType {
+		case ldap.AddAttribute:
+			entry[attr] = append(entry[attr], values...)
+		case ldap.ReplaceAttribute:
+			entry[attr] = append([]string(nil), values...)
+		case ldap.DeleteAttribute:
+			delete(entry, attr)
+		}
+	}
+	return nil
+}
+
+// ModifyEntryAttributes records a modify operation and applies the attribute changes
+func (m *MockClient) ModifyEntryAttributes(dn string, changes []AttributeChange) error {
+	if err := m.Failures[dn]; err != nil {
+		return err
+	}
 	entityType, entityID := getEntityTypeAndID(dn)
 
 	m.Operations = append(m.Operations, MockOperation{
@@ -70,11 +112,52 @@ func (m *MockClient) ModifyEntry(dn string, attrs map[string][]string, modType i
 		EntityID: entityID,
 		Type:     entityType,
 	})
+
+	entry := m.Entries[dn]
+	if entry == nil {
+		entry = make(map[string][]string)
+		m.Entries[dn] = entry
+	}
+	for _, change := range changes {
+		if len(change.New) > 0 {
+			entry[change.Name] = append([]string(nil), change.New...)
+		} else {
+			delete(entry, change.Name)
+		}
+	}
+	return nil
+}
+
+// MoveEntry records a move operation and moves the entry and its attributes to the new parent
+func (m *MockClient) MoveEntry(dn string, newSuperior string) error {
+	if err := m.Failures[dn]; err != nil {
+		return err
+	}
+	entityType, entityID := getEntityTypeAndID(dn)
+
+	m.Operations = append(m.Operations, MockOperation{
+		OpType:   "move",
+		DN:       dn,
+		EntityID: entityID,
+		Type:     entityType,
+	})
+
+	rdn, _ := splitDN(dn)
+	newDN := rdn + "," + newSuperior
+	m.Existing[dn] = false
+	m.Existing[newDN] = true
+	if attrs, ok := m.Entries[dn]; ok {
+		m.Entries[newDN] = attrs
+		delete(m.Entries, dn)
+	}
 	return nil
 }
 
 // DeleteEntry records a delete operation and marks the entry as non-existing
 func (m *MockClient) DeleteEntry(dn string) error {
+	if err := m.Failures[dn]; err != nil {
+		return err
+	}
 	entityType, entityID := getEntityTypeAndID(dn)
 
 	m.Operations = append(m.Operations, MockOperation{
@@ -84,9 +167,21 @@ func (m *MockClient) DeleteEntry(dn string) error {
 		Type:     entityType,
 	})
 	m.Existing[dn] = false
+	delete(m.Entries, dn)
 	return nil
 }
 
+// GetEntryAttributes returns the stored attributes of an entry, or nil if it does not exist
+func (m *MockClient) GetEntryAttributes(dn string) (map[string][]string, error) {
+	if !m.Existing[dn] {
+		return nil, nil
+	}
+	if attrs, ok := m.Entries[dn]; ok {
+		return copyAttributes(attrs), nil
+	}
+	return make(map[string][]string), nil
+}
+
 // GetExistingEntries returns a map of DNs that exist in the specified OU
 func (m *MockClient) GetExistingEntries(ou string, entryType string) (map[string]string, error) {
 	result := make(map[string]string)
@@ -107,6 +202,12 @@ func (m *MockClient) EnsureManagedOUsExist() error {
 	m.Existing[m.config.LDAPEnforcer.EnforcedPeopleOU] = true
 	m.Existing[m.config.LDAPEnforcer.EnforcedSvcAcctOU] = true
 	m.Existing[m.config.LDAPEnforcer.EnforcedGroupOU] = true
+	if m.config.LDAPEnforcer.PrivateGroupOU != "" {
+		m.Existing[m.config.LDAPEnforcer.PrivateGroupOU] = true
+	}
+	if m.config.LDAPEnforcer.QuarantineOU != "" {
+		m.Existing[m.config.LDAPEnforcer.QuarantineOU] = true
+	}
 	return nil
 }
 
@@ -116,238 +217,78 @@ func (m *MockClient) EnsureOUExists(ou string) error {
 	return nil
 }
 
-// SyncPerson ensures that a person in LDAP matches the configuration
+// SyncPerson ensures that a person in the mock state matches the configuration,
+// modifying only the attributes that differ
 func (m *MockClient) SyncPerson(uid string, person *model.Person) error {
-	dn := m.PersonToDN(uid)
-	exists, _ := m.EntryExists(dn)
-
-	if exists {
-		return m.ModifyEntry(dn, nil, ldap.ReplaceAttribute)
-	} else {
-		return m.CreateEntry(dn, nil)
+	change, err := planPerson(m, uid, person)
+	if err != nil {
+		return err
 	}
+	return syncChange(m, change)
 }
 
-// SyncSvcAcct ensures that a service account in LDAP matches the configuration
+// SyncSvcAcct ensures that a service account in the mock state matches the configuration,
+// modifying only the attributes that differ
 func (m *MockClient) SyncSvcAcct(uid string, svcacct *model.SvcAcct) error {
-	dn := m.SvcAcctToDN(uid)
-	exists, _ := m.EntryExists(dn)
-
-	if exists {
-		return m.ModifyEntry(dn, nil, ldap.ReplaceAttribute)
-	} else {
-		return m.CreateEntry(dn, nil)
+	change, err := planSvcAcct(m, uid, svcacct)
+	if err != nil {
+		return err
 	}
+	return syncChange(m, change)
 }
 
-// SyncGroup ensures that a group in LDAP matches the configuration
+// SyncGroup ensures that a group in the mock state matches the configuration,
+// modifying only the attributes that differ
 func (m *MockClient) SyncGroup(groupname string, group *model.Group) error {
-	dn := m.GroupToDN(groupname)
-	exists, _ := m.EntryExists(dn)
-
-	// Get all members
-	members, _ := model.GetGroupMembers(
-		groupname,
-		m.config.LDAPEnforcer.Group,
-		m.config.LDAPEnforcer.Person,
-		m.config.LDAPEnforcer.SvcAcct,
-		m.config.LDAPEnforcer.EnforcedPeopleOU,
-		m.config.LDAPEnforcer.EnforcedSvcAcctOU,
-		m.config.LDAPEnforcer.EnforcedGroupOU,
-	)
-
-	// If group has no members, handle appropriately
-	if len(members) == 0 {
-		if exists {
-			return m.DeleteEntry(dn)
-		}
-		return nil
+	change, err := planGroup(m, groupname, group)
+	if err != nil {
+		return err
 	}
+	return syncChange(m, change)
+}
 
-	if exists {
-		return m.ModifyEntry(dn, nil, ldap.ReplaceAttribute)
-	} else {
-		return m.CreateEntry(dn, nil)
-	}
+// PlanSync computes the changes SyncAll would make without modifying the mock state
+func (m *MockClient) PlanSync() (*SyncPlan, error) {
+	return buildSyncPlan(m, m.config)
+}
+
+// PrepareConfig fills in allocated POSIX numbers and user private groups, without recording them
+func (m *MockClient) PrepareConfig() error {
+	_, err := prepareConfig(m, m.config)
+	return err
 }
 
 // SyncAll synchronizes all configured entities with LDAP
 func (m *MockClient) SyncAll() error {
-	// Ensure all required OUs exist
-	err := m.EnsureManagedOUsExist()
+	// Determine what needs to be added, modified, and deleted
+	cls, err := classifySync(m, m.config)
 	if err != nil {
 		return err
 	}
 
-	// First, get existing entities to determine what needs to be added, modified, and deleted
-	existingPeople, err := m.GetExistingEntries(m.config.LDAPEnforcer.EnforcedPeopleOU, "person")
+	// Check the deletion limits before making any change
+	err = checkDeletionLimits(m.config, cls.deletions(m), cls.existingCount)
 	if err != nil {
-		return fmt.Errorf("failed to get existing people: %w", err)
+		return err
 	}
 
-	existingSvcAccts, err := m.GetExistingEntries(m.config.LDAPEnforcer.EnforcedSvcAcctOU, "svcacct")
+	// Record allocated POSIX numbers and the attribute names about to be written before any entry uses them
+	err = recordPosixAllocations(m.config, cls.posixAllocations)
 	if err != nil {
-		return fmt.Errorf("failed to get existing service accounts: %w", err)
+		return err
 	}
-
-	existingGroups, err := m.GetExistingEntries(m.config.LDAPEnforcer.EnforcedGroupOU, "group")
+	err = recordWrittenState(m, m.config)
 	if err != nil {
-		return fmt.Errorf("failed to get existing groups: %w", err)
-	}
-
-	// Build a DAG of all entities to determine proper operation order
-	peopleToAdd := make(map[string]*model.Person)
-	peopleToModify := make(map[string]*model.Person)
-	peopleToDelete := make(map[string]string) // DN as value
-
-	svcAcctsToAdd := make(map[string]*model.SvcAcct)
-	svcAcctsToModify := make(map[string]*model.SvcAcct)
-	svcAcctsToDelete := make(map[string]string) // DN as value
-
-	groupsToAdd := make(map[string]*model.Group)
-	groupsToModify := make(map[string]*model.Group)
-	groupsToDelete := make(map[string]string) // DN as value
-
-	// Determine people and service accounts to add, modify, or delete
-	for uid, person := range m.config.LDAPEnforcer.Person {
-		dn := m.PersonToDN(uid)
-		if _, exists := existingPeople[dn]; exists {
-			peopleToModify[uid] = person
-			delete(existingPeople, dn) // Remove from existing so we know what to delete
-		} else {
-			peopleToAdd[uid] = person
-		}
-	}
-
-	for uid, svcacct := range m.config.LDAPEnforcer.SvcAcct {
-		dn := m.SvcAcctToDN(uid)
-		if _, exists := existingSvcAccts[dn]; exists {
-			svcAcctsToModify[uid] = svcacct
-			delete(existingSvcAccts, dn) // Remove from existing so we know what to delete
-		} else {
-			svcAcctsToAdd[uid] = svcacct
-		}
-	}
-
-	// Any remaining entries in existingPeople/existingSvcAccts are not in config and should be deleted
-	for dn := range existingPeople {
-		peopleToDelete[dn] = dn
-	}
-	for dn := range existingSvcAccts {
-		svcAcctsToDelete[dn] = dn
-	}
-
-	// Build the group dependency graph
-	// Keep track of processed groups to avoid cycles
-	processedGroups := make(map[string]bool)
-	groupDeps := make(map[string][]string)           // Map of groupname -> groups it depends on
-	unresolvableMembers := make(map[string][]string) // Map of groupname -> unresolvable member UIDs
-
-	// Process all groups to determine dependencies and operation type
-	for groupname, group := range m.config.LDAPEnforcer.Group {
-		dn := m.GroupToDN(groupname)
-		if _, exists := existingGroups[dn]; exists {
-			groupsToModify[groupname] = group
-			delete(existingGroups, dn) // Remove from existing so we know what to delete
-		} else {
-			groupsToAdd[groupname] = group
-		}
-
-		// Collect dependencies for this group
-		deps, unresMem := m.getGroupDependencies(groupname, processedGroups)
-		if len(deps) > 0 {
-			groupDeps[groupname] = deps
-		}
-		if len(unresMem) > 0 {
-			unresolvableMembers[groupname] = unresMem
-		}
-	}
-
-	// Any remaining entries in existingGroups are not in config and should be deleted
-	for dn := range existingGroups {
-		groupsToDelete[dn] = dn
-	}
-
-	// Log any unresolvable members
-	for groupname, members := range unresolvableMembers {
-		for _, member := range members {
-			logging.DefaultLogger.Warn("Unresolvable member %s in group %s", member, groupname)
-		}
-	}
-
-	// Execute operations in the correct order:
-	// 1. First add and modify people and service accounts
-	for uid, person := range peopleToAdd {
-		err := m.SyncPerson(uid, person)
-		if err != nil {
-			return fmt.Errorf("failed to add person %s: %w", uid, err)
-		}
-	}
-
-	for uid, person := range peopleToModify {
-		err := m.SyncPerson(uid, person)
-		if err != nil {
-			return fmt.Errorf("failed to modify person %s: %w", uid, err)
-		}
-	}
-
-	for uid, svcacct := range svcAcctsToAdd {
-		err := m.SyncSvcAcct(uid, svcacct)
-		if err != nil {
-			return fmt.Errorf("failed to add service account %s: %w", uid, err)
-		}
-	}
-
-	for uid, svcacct := range svcAcctsToModify {
-		err := m.SyncSvcAcct(uid, svcacct)
-		if err != nil {
-			return fmt.Errorf("failed to modify service account %s: %w", uid, err)
-		}
-	}
-
-	// 2. Then add and modify groups in dependency order (leaf groups first)
-	// Get groups in order
-	groupOrder := m.topologicalSortGroups(groupDeps)
-
-	// Add and modify groups in order (leaf groups first)
-	for _, groupname := range groupOrder {
-		// Check if it's in our add or modify list
-		if group, ok := groupsToAdd[groupname]; ok {
-			err := m.SyncGroup(groupname, group)
-			if err != nil {
-				return fmt.Errorf("failed to add group %s: %w", groupname, err)
-			}
-		} else if group, ok := groupsToModify[groupname]; ok {
-			err := m.SyncGroup(groupname, group)
-			if err != nil {
-				return fmt.Errorf("failed to modify group %s: %w", groupname, err)
-			}
-		}
-	}
-
-	// 3. Finally delete entities (groups first)
-	for _, dn := range groupsToDelete {
-		err := m.DeleteEntry(dn)
-		if err != nil {
-			return fmt.Errorf("failed to delete group %s: %w", dn, err)
-		}
-	}
-
-	for _, dn := range peopleToDelete {
-		err := m.DeleteEntry(dn)
-		if err != nil {
-			return fmt.Errorf("failed to delete person %s: %w", dn, err)
-		}
+		return err
 	}
 
-	for _, dn := range svcAcctsToDelete {
-		err := m.DeleteEntry(dn)
-		if err != nil {
-			return fmt.Errorf("failed to delete service account %s: %w", dn, err)
-		}
+	// Ensure all required OUs exist
+	err = m.EnsureManagedOUsExist()
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return executeSync(m, cls, m.config.LDAPEnforcer.ContinueOnError)
 }
 
 // Helper function to determine entity type and ID from a DN
@@ -381,3 +322,12 @@ func isDNInOU(dn, ou string) bool {
 	// Check if dn ends with ou
 	return len(dn) > len(ou) && dn[len(dn)-len(ou):] == ou
 }
+
+// Helper function to copy an attribute map
+func copyAttributes(attrs map[string][]string) map[string][]string {
+	result := make(map[string][]string, len(attrs))
+	for attr, values := range attrs {
+		result[attr] = append([]string(nil), values...)
+	}
+	return result
+}
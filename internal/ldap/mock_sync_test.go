@@ -113,3 +113,39 @@ func TestGroupUserReplacement(t *testing.T) {
 		}
 	}
 }
+
+// TestMockSyncPersonOnlyModifiesDifferences tests that the mock SyncPerson
+// plans the entry like the real client and leaves a matching entry alone.
+func TestMockSyncPersonOnlyModifiesDifferences(t *testing.T) {
+	testConfig := &config.Config{
+		LDAPEnforcer: config.LDAPEnforcerConfig{
+			EnforcedPeopleOU:  "ou=people,dc=example,dc=com",
+			EnforcedSvcAcctOU: "ou=svcaccts,dc=example,dc=com",
+			EnforcedGroupOU:   "ou=groups,dc=example,dc=com",
+		},
+	}
+	person := &model.Person{CN: "Alice", GivenName: "Alice", SN: "Smith", Mail: "alice@example.com"}
+
+	mockClient := NewMockClient(testConfig)
+	if err := mockClient.SyncPerson("alice", person); err != nil {
+		t.Fatalf("SyncPerson failed: %v", err)
+	}
+	if err := mockClient.SyncPerson("alice", person); err != nil {
+		t.Fatalf("SyncPerson failed: %v", err)
+	}
+	if len(mockClient.Operations) != 1 || mockClient.Operations[0].OpType != "create" {
+		t.Fatalf("Expected a single create operation, got %+v", mockClient.Operations)
+	}
+
+	person.Mail = "alice@example.org"
+	if err := mockClient.SyncPerson("alice", person); err != nil {
+		t.Fatalf("SyncPerson failed: %v", err)
+	}
+	if len(mockClient.Operations) != 2 || mockClient.Operations[1].OpType != "modify" {
+		t.Fatalf("Expected a modify operation, got %+v", mockClient.Operations)
+	}
+	dn := "uid=alice," + testConfig.LDAPEnforcer.EnforcedPeopleOU
+	if got := mockClient.Entries[dn]["mail"]; len(got) != 1 || got[0] != "alice@example.org" {
+		t.Errorf("Expected mail to be updated, got %v", got)
+	}
+}
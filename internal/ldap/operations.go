@@ -1,18 +1,52 @@
 package ldap
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
+	"github.com/mrled/ldapenforcer/internal/config"
 	"github.com/mrled/ldapenforcer/internal/logging"
 	"github.com/mrled/ldapenforcer/internal/model"
 )
 
 // These DN methods are now provided by BaseClient
 
+// ErrGroupHasNoMembers is returned when a group resolves to no members,
+// which groupOfNames does not permit
+var ErrGroupHasNoMembers = errors.New("group has no members after resolving")
+
+// ownedAttributes lists the built-in attributes the enforcer manages for each entity type.
+// An owned attribute that the configuration no longer produces is deleted from the entry.
+// Attributes that are not owned, such as userPassword or operational attributes, are never removed.
+// nsAccountLock is not owned either: it is only set for accounts that set disabled or expires,
+// and unlocked once for accounts a sync locked before they stopped setting them,
+// so that locks set by hand or by a password policy survive on other accounts.
+// See managedAttributes for the attributes owned through the attributes table.
+var ownedAttributes = map[string][]string{
+	"person": {
+		"objectClass", "cn", "sn", "givenName", "mail",
+		"uidNumber", "gidNumber", "homeDirectory", "loginShell",
+		"sshPublicKey", "shadowExpire",
+	},
+	"svcacct": {
+		"objectClass", "cn", "sn", "description", "mail",
+		"uidNumber", "gidNumber", "homeDirectory", "loginShell",
+		"sshPublicKey", "shadowExpire",
+	},
+	"group": {
+		"objectClass", "cn", "description", "member", "gidNumber",
+		"memberUid",
+	},
+}
+
 // GetPersonAttributes converts a Person to LDAP attributes
-func GetPersonAttributes(person *model.Person) map[string][]string {
+func (b *BaseClient) GetPersonAttributes(person *model.Person) map[string][]string {
 	// Base object classes
 	objectClasses := []string{"top", "inetOrgPerson", "nsMemberOf"}
 
@@ -23,6 +57,11 @@ func GetPersonAttributes(person *model.Person) map[string][]string {
 		objectClasses = append(objectClasses, "account")
 	}
 
+	// Add ldapPublicKey for SSH keys
+	if len(person.SSHKeys) > 0 {
+		objectClasses = append(objectClasses, "ldapPublicKey")
+	}
+
 	attrs := map[string][]string{
 		"objectClass": objectClasses,
 		"cn":          {person.CN},
@@ -36,27 +75,43 @@ func GetPersonAttributes(person *model.Person) map[string][]string {
 	if person.Mail != "" {
 		attrs["mail"] = []string{person.Mail}
 	}
+	if person.PasswordHash != "" {
+		attrs["userPassword"] = []string{person.PasswordHash}
+	}
+	if len(person.SSHKeys) > 0 {
+		attrs["sshPublicKey"] = append([]string(nil), person.SSHKeys...)
+	}
 
 	// Add POSIX attributes if set
 	if person.IsPosix() {
 		attrs["uidNumber"] = []string{strconv.Itoa(person.GetUIDNumber())}
 		attrs["gidNumber"] = []string{strconv.Itoa(person.GetGIDNumber())}
 
-		// Set homeDirectory based on username field
-		if person.Username != "" {
-			attrs["homeDirectory"] = []string{fmt.Sprintf("/home/%s", person.Username)}
-		} else {
-			attrs["homeDirectory"] = []string{"/nonexistent"}
+		attrs["homeDirectory"] = []string{b.config.GetHomeDirectory(person.Username, person.HomeDirectory)}
+		attrs["loginShell"] = []string{b.config.GetPersonLoginShell(person)}
+
+		// shadowExpire lets POSIX clients like sssd enforce the expiry date themselves
+		if shadowExpire := person.GetShadowExpire(); shadowExpire != 0 {
+			attrs["objectClass"] = append(attrs["objectClass"], "shadowAccount")
+			attrs["shadowExpire"] = []string{strconv.Itoa(shadowExpire)}
 		}
+	}
 
-		attrs["loginShell"] = []string{"/bin/bash"}
+	// Lock disabled and expired accounts, and unlock the others that set disabled or expires,
+	// or that a sync locked before they stopped setting them
+	if person.IsLocked(time.Now()) {
+		attrs["nsAccountLock"] = []string{"TRUE"}
+	} else if person.ManagesLock() || b.lockWasEnforced("person", person.Username) {
+		attrs["nsAccountLock"] = []string{"FALSE"}
 	}
 
+	addExtraAttributes(attrs, person.Attributes, person.ExtraObjectClasses)
+
 	return attrs
 }
 
 // GetSvcAcctAttributes converts a SvcAcct to LDAP attributes
-func GetSvcAcctAttributes(svcacct *model.SvcAcct) map[string][]string {
+func (b *BaseClient) GetSvcAcctAttributes(svcacct *model.SvcAcct) map[string][]string {
 	// Base object classes
 	objectClasses := []string{"top", "inetOrgPerson", "nsMemberOf"}
 
@@ -67,6 +122,11 @@ func GetSvcAcctAttributes(svcacct *model.SvcAcct) map[string][]string {
 		objectClasses = append(objectClasses, "account")
 	}
 
+	// Add ldapPublicKey for SSH keys
+	if len(svcacct.SSHKeys) > 0 {
+		objectClasses = append(objectClasses, "ldapPublicKey")
+	}
+
 	attrs := map[string][]string{
 		"objectClass": objectClasses,
 		"cn":          {svcacct.CN},
@@ -78,27 +138,43 @@ func GetSvcAcctAttributes(svcacct *model.SvcAcct) map[string][]string {
 	if svcacct.Mail != "" {
 		attrs["mail"] = []string{svcacct.Mail}
 	}
+	if svcacct.PasswordHash != "" {
+		attrs["userPassword"] = []string{svcacct.PasswordHash}
+	}
+	if len(svcacct.SSHKeys) > 0 {
+		attrs["sshPublicKey"] = append([]string(nil), svcacct.SSHKeys...)
+	}
 
 	// Add POSIX attributes if set
 	if svcacct.IsPosix() {
 		attrs["uidNumber"] = []string{strconv.Itoa(svcacct.GetUIDNumber())}
 		attrs["gidNumber"] = []string{strconv.Itoa(svcacct.GetGIDNumber())}
 
-		// Set homeDirectory based on username field
-		if svcacct.Username != "" {
-			attrs["homeDirectory"] = []string{fmt.Sprintf("/home/%s", svcacct.Username)}
-		} else {
-			attrs["homeDirectory"] = []string{"/nonexistent"}
+		attrs["homeDirectory"] = []string{b.config.GetHomeDirectory(svcacct.Username, svcacct.HomeDirectory)}
+		attrs["loginShell"] = []string{b.config.GetSvcAcctLoginShell(svcacct)}
+
+		// shadowExpire lets POSIX clients like sssd enforce the expiry date themselves
+		if shadowExpire := svcacct.GetShadowExpire(); shadowExpire != 0 {
+			attrs["objectClass"] = append(attrs["objectClass"], "shadowAccount")
+			attrs["shadowExpire"] = []string{strconv.Itoa(shadowExpire)}
 		}
+	}
 
-		attrs["loginShell"] = []string{"/usr/sbin/nologin"}
+	// Lock disabled and expired accounts, and unlock the others that set disabled or expires,
+	// or that a sync locked before they stopped setting them
+	if svcacct.IsLocked(time.Now()) {
+		attrs["nsAccountLock"] = []string{"TRUE"}
+	} else if svcacct.ManagesLock() || b.lockWasEnforced("svcacct", svcacct.Username) {
+		attrs["nsAccountLock"] = []string{"FALSE"}
 	}
 
+	addExtraAttributes(attrs, svcacct.Attributes, svcacct.ExtraObjectClasses)
+
 	return attrs
 }
 
 // GetGroupAttributes converts a Group to LDAP attributes
-func (c *Client) GetGroupAttributes(groupname string, group *model.Group) (map[string][]string, error) {
+func (b *BaseClient) GetGroupAttributes(groupname string, group *model.Group) (map[string][]string, error) {
 	attrs := map[string][]string{
 		"objectClass": {"top", "groupOfNames"},
 		"cn":          {groupname},
@@ -108,26 +184,51 @@ func (c *Client) GetGroupAttributes(groupname string, group *model.Group) (map[s
 	// Get all members including from nested groups
 	members, err := model.GetGroupMembers(
 		groupname,
-		c.config.LDAPEnforcer.Group,
-		c.config.LDAPEnforcer.Person,
-		c.config.LDAPEnforcer.SvcAcct,
-		c.config.LDAPEnforcer.EnforcedPeopleOU,
-		c.config.LDAPEnforcer.EnforcedSvcAcctOU,
-		c.config.LDAPEnforcer.EnforcedGroupOU,
+		b.config.LDAPEnforcer.Group,
+		b.config.LDAPEnforcer.Person,
+		b.config.LDAPEnforcer.SvcAcct,
+		b.config.LDAPEnforcer.EnforcedPeopleOU,
+		b.config.LDAPEnforcer.EnforcedSvcAcctOU,
+		b.config.LDAPEnforcer.EnforcedGroupOU,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The member attribute lists nested groups according to the nesting mode
+	listed, err := model.ResolveGroupMembers(
+		groupname,
+		b.config.GetGroupNesting(group),
+		b.config.LDAPEnforcer.Group,
+		b.config.LDAPEnforcer.Person,
+		b.config.LDAPEnforcer.SvcAcct,
+		b.config.LDAPEnforcer.EnforcedPeopleOU,
+		b.config.LDAPEnforcer.EnforcedSvcAcctOU,
+		b.config.LDAPEnforcer.EnforcedGroupOU,
+		b.config.GetPrivateGroupOU(),
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	// Add all member DNs
+	// Add all member DNs, once each even if they are reached through several nested groups
 	var memberDNs []string
-	for _, member := range members {
+	seen := make(map[string]bool)
+	for _, member := range listed {
+		if seen[strings.ToLower(member.DN)] {
+			continue
+		}
+		seen[strings.ToLower(member.DN)] = true
 		memberDNs = append(memberDNs, member.DN)
 	}
 
-	// We require at least one member for a valid group
+	// We require at least one member for a valid group, unless the group may be empty,
+	// in which case a placeholder satisfies groupOfNames
 	if len(memberDNs) == 0 {
-		return nil, fmt.Errorf("group has no members after resolving: %s", groupname)
+		if !group.AllowEmpty {
+			return nil, fmt.Errorf("%w: %s", ErrGroupHasNoMembers, groupname)
+		}
+		memberDNs = []string{b.config.GetEmptyGroupPlaceholder()}
 	}
 
 	attrs["member"] = memberDNs
@@ -136,11 +237,118 @@ func (c *Client) GetGroupAttributes(groupname string, group *model.Group) (map[s
 	if group.IsPosix() {
 		attrs["objectClass"] = append(attrs["objectClass"], "posixGroup")
 		attrs["gidNumber"] = []string{strconv.Itoa(group.PosixGidNumber)}
+
+		// RFC 2307 clients like nss_ldap only see POSIX members through memberUid,
+		// which cannot express nesting, so it always lists the flattened members
+		if memberUIDs := posixMemberUIDs(members); len(memberUIDs) > 0 {
+			attrs["memberUid"] = memberUIDs
+		}
 	}
 
+	addExtraAttributes(attrs, group.Attributes, group.ExtraObjectClasses)
+
 	return attrs, nil
 }
 
+// posixMemberUIDs returns the uids of the POSIX members of a group, without duplicates
+func posixMemberUIDs(members []*model.Member) []string {
+	var uids []string
+	seen := make(map[string]bool)
+	for _, member := range members {
+		if !member.IsPosix || seen[member.UID] {
+			continue
+		}
+		seen[member.UID] = true
+		uids = append(uids, member.UID)
+	}
+	return uids
+}
+
+// addExtraAttributes merges an entity's attributes table and extra_object_classes into its generated attributes.
+// Config validation keeps the attributes table from overriding generated attributes.
+func addExtraAttributes(attrs map[string][]string, extra map[string][]string, objectClasses []string) {
+	for name, values := range extra {
+		attrs[name] = append([]string(nil), values...)
+	}
+
+	for _, objectClass := range objectClasses {
+		present := false
+		for _, existing := range attrs["objectClass"] {
+			if strings.EqualFold(existing, objectClass) {
+				present = true
+				break
+			}
+		}
+		if !present {
+			attrs["objectClass"] = append(attrs["objectClass"], objectClass)
+		}
+	}
+}
+
+// managedAttributes returns the attributes the enforcer owns for an entity type:
+// the built-in attributes, every attribute that any entity of that type sets in its attributes table,
+// and every attribute from an attributes table that a sync has recorded in the state file.
+// An attribute that is removed from an entity's table is deleted from that entry.
+// Without a state file, once no entity of the type sets an attribute any more, existing values are left alone.
+func (b *BaseClient) managedAttributes(entityType string) []string {
+	owned := append([]string(nil), ownedAttributes[entityType]...)
+	seen := make(map[string]bool)
+	for _, name := range owned {
+		seen[strings.ToLower(name)] = true
+	}
+
+	var names []string
+	var written []string
+	if b.written != nil {
+		written = b.written.Attributes[entityType]
+	}
+	for _, name := range append(configuredAttributes(b.config, entityType), written...) {
+		if !seen[strings.ToLower(name)] {
+			seen[strings.ToLower(name)] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append(owned, names...)
+}
+
+// setWrittenState sets what earlier syncs recorded writing in the state file
+func (b *BaseClient) setWrittenState(state *posixState) {
+	b.written = state
+}
+
+// lockWasEnforced returns true if a sync recorded setting the nsAccountLock of an account in the state file
+func (b *BaseClient) lockWasEnforced(entityType, uid string) bool {
+	return b.written != nil && slices.Contains(b.written.Locks[entityType], uid)
+}
+
+// configuredAttributes returns the names of the attributes that the entities of a type set in their attributes tables
+func configuredAttributes(cfg *config.Config, entityType string) []string {
+	var extra []map[string][]string
+	switch entityType {
+	case "person":
+		for _, person := range cfg.LDAPEnforcer.Person {
+			extra = append(extra, person.Attributes)
+		}
+	case "svcacct":
+		for _, svcacct := range cfg.LDAPEnforcer.SvcAcct {
+			extra = append(extra, svcacct.Attributes)
+		}
+	case "group":
+		for _, group := range cfg.LDAPEnforcer.Group {
+			extra = append(extra, group.Attributes)
+		}
+	}
+
+	var names []string
+	for _, attributes := range extra {
+		for name := range attributes {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // EnsureManagedOUsExist ensures that all required OUs for managed objects exist
 func (c *Client) EnsureManagedOUsExist() error {
 	// Ensure people OU exists
@@ -161,59 +369,43 @@ func (c *Client) EnsureManagedOUsExist() error {
 		return fmt.Errorf("failed to ensure group OU exists: %w", err)
 	}
 
+	// Ensure private group OU exists, if configured
+	if c.config.LDAPEnforcer.PrivateGroupOU != "" {
+		err = c.EnsureOUExists(c.config.LDAPEnforcer.PrivateGroupOU)
+		if err != nil {
+			return fmt.Errorf("failed to ensure private group OU exists: %w", err)
+		}
+	}
+
+	// Ensure quarantine OU exists, if configured
+	if c.config.LDAPEnforcer.QuarantineOU != "" {
+		err = c.EnsureOUExists(c.config.LDAPEnforcer.QuarantineOU)
+		if err != nil {
+			return fmt.Errorf("failed to ensure quarantine OU exists: %w", err)
+		}
+	}
+
 	return nil
 }
 
-// SyncPerson ensures that a person in LDAP matches the configuration
+// SyncPerson ensures that a person in LDAP matches the configuration,
+// modifying only the attributes that differ
 func (c *Client) SyncPerson(uid string, person *model.Person) error {
-	dn := c.PersonToDN(uid)
-	exists, err := c.EntryExists(dn)
+	change, err := planPerson(c, uid, person)
 	if err != nil {
 		return err
 	}
-
-	// Set the Username field with the uid if it's not already set
-	if person.Username == "" {
-		person.Username = uid
-	}
-
-	attrs := GetPersonAttributes(person)
-
-	if exists {
-		logging.LDAPProtocolLogger.Trace("Updating person: %s", dn)
-		return c.ModifyEntry(dn, attrs, ldap.ReplaceAttribute)
-	} else {
-		logging.LDAPProtocolLogger.Trace("Creating person: %s", dn)
-		// Add the uid attribute which is required
-		attrs["uid"] = []string{uid}
-		return c.CreateEntry(dn, attrs)
-	}
+	return syncChange(c, change)
 }
 
-// SyncSvcAcct ensures that a service account in LDAP matches the configuration
+// SyncSvcAcct ensures that a service account in LDAP matches the configuration,
+// modifying only the attributes that differ
 func (c *Client) SyncSvcAcct(uid string, svcacct *model.SvcAcct) error {
-	dn := c.SvcAcctToDN(uid)
-	exists, err := c.EntryExists(dn)
+	change, err := planSvcAcct(c, uid, svcacct)
 	if err != nil {
 		return err
 	}
-
-	// Set the Username field with the uid if it's not already set
-	if svcacct.Username == "" {
-		svcacct.Username = uid
-	}
-
-	attrs := GetSvcAcctAttributes(svcacct)
-
-	if exists {
-		logging.LDAPProtocolLogger.Trace("Updating service account: %s", dn)
-		return c.ModifyEntry(dn, attrs, ldap.ReplaceAttribute)
-	} else {
-		logging.LDAPProtocolLogger.Trace("Creating service account: %s", dn)
-		// Add the uid attribute which is required
-		attrs["uid"] = []string{uid}
-		return c.CreateEntry(dn, attrs)
-	}
+	return syncChange(c, change)
 }
 
 // GetExistingEntries returns a map of DNs to entities for the given OU and type
@@ -258,333 +450,202 @@ func (c *Client) GetExistingEntries(ou string, entryType string) (map[string]str
 	return entries, nil
 }
 
-// getGroupDependencies returns a list of groups that this group depends on
-// and a list of unresolvable member UIDs
-func (c *Client) getGroupDependencies(groupname string, processedGroups map[string]bool) ([]string, []string) {
-	// Mark this group as visited to avoid infinite recursion
-	processedGroups[groupname] = true
-
-	// Get the group
-	group, ok := c.config.LDAPEnforcer.Group[groupname]
-	if !ok {
-		return nil, nil
-	}
-
-	// Track dependencies and unresolvable members
-	var dependencies []string
-	var unresolvableMembers []string
-
-	// Check people members
-	for _, uid := range group.People {
-		if _, ok := c.config.LDAPEnforcer.Person[uid]; !ok {
-			unresolvableMembers = append(unresolvableMembers, uid)
-		}
-	}
-
-	// Check service account members
-	for _, uid := range group.SvcAccts {
-		if _, ok := c.config.LDAPEnforcer.SvcAcct[uid]; !ok {
-			unresolvableMembers = append(unresolvableMembers, uid)
-		}
-	}
-
-	// Check group members and recursively build dependencies
-	for _, nestedGroupName := range group.Groups {
-		// Add as dependency
-		dependencies = append(dependencies, nestedGroupName)
-
-		// If we haven't processed this nested group yet, get its dependencies too
-		if !processedGroups[nestedGroupName] {
-			nestedDeps, nestedUnres := c.getGroupDependencies(nestedGroupName, processedGroups)
-			dependencies = append(dependencies, nestedDeps...)
-			unresolvableMembers = append(unresolvableMembers, nestedUnres...)
-		}
+// SyncGroup ensures that a group in LDAP matches the configuration,
+// modifying only the attributes that differ
+func (c *Client) SyncGroup(groupname string, group *model.Group) error {
+	change, err := planGroup(c, groupname, group)
+	if err != nil {
+		return err
 	}
-
-	return dependencies, unresolvableMembers
+	return syncChange(c, change)
 }
 
-// topologicalSortGroups returns a slice of group names in topological order
-// (dependencies first, dependents later)
-func (c *Client) topologicalSortGroups(deps map[string][]string) []string {
-	// First, get all groups (including those with no dependencies)
-	allGroups := make(map[string]bool)
-	for groupname := range c.config.LDAPEnforcer.Group {
-		allGroups[groupname] = true
-	}
-	for groupname, groupDeps := range deps {
-		allGroups[groupname] = true
-		for _, dep := range groupDeps {
-			allGroups[dep] = true
-		}
-	}
-
-	// Now, build a proper adjacency list from the dependencies
-	graph := make(map[string][]string)
-	for group := range allGroups {
-		graph[group] = []string{}
+// syncChange applies a single planned change, reporting entries that are already up to date.
+// A nil change means there is nothing to do.
+func syncChange(c LDAPClientInterface, change *EntityChange) error {
+	if change == nil {
+		return nil
 	}
-	for group, groupDeps := range deps {
-		// Add all dependencies at once
-		graph[group] = append(graph[group], groupDeps...)
+	if change.Action == ChangeUnchanged {
+		logging.DefaultLogger.Debug("%s %s is unchanged", change.EntityType, change.DN)
+		return nil
 	}
-
-	// Perform topological sort
-	visited := make(map[string]bool)
-	temp := make(map[string]bool) // For cycle detection
-	var order []string
-
-	// Visit function for DFS
-	var visit func(string)
-	visit = func(node string) {
-		// If we've already processed this node, skip
-		if visited[node] {
-			return
-		}
-
-		// If we're currently processing this node, we have a cycle
-		if temp[node] {
-			// We have a cycle, but we should still proceed
-			logging.DefaultLogger.Debug("Cyclic dependency detected involving group: %s", node)
-			return
-		}
-
-		temp[node] = true
-
-		// Visit all dependencies before adding this node
-		for _, dep := range graph[node] {
-			visit(dep)
-		}
-
-		temp[node] = false
-		visited[node] = true
-		order = append(order, node)
-	}
-
-	// Visit each node
-	for node := range graph {
-		if !visited[node] {
-			visit(node)
-		}
-	}
-
-	// Reverse the order to get dependencies first
-	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
-		order[i], order[j] = order[j], order[i]
-	}
-
-	return order
+	return applyChange(c, change)
 }
 
-// SyncGroup ensures that a group in LDAP matches the configuration
-func (c *Client) SyncGroup(groupname string, group *model.Group) error {
-	dn := c.GroupToDN(groupname)
-	exists, err := c.EntryExists(dn)
-	if err != nil {
-		return err
-	}
-
-	attrs, err := c.GetGroupAttributes(groupname, group)
-	if err != nil {
-		// If the error indicates an empty group
-		if err.Error() == fmt.Sprintf("group has no members after resolving: %s", groupname) {
-			if exists {
-				logging.DefaultLogger.Warn("Deleting memberless group %s (add at least one member)", groupname)
-				return c.DeleteEntry(dn)
-			} else {
-				logging.DefaultLogger.Warn("Refusing to create memberless group %s (add at least one member)", groupname)
-			}
-			return nil
-		}
-		return err
-	}
+// PlanSync computes the changes SyncAll would make without modifying LDAP
+func (c *Client) PlanSync() (*SyncPlan, error) {
+	return buildSyncPlan(c, c.config)
+}
 
-	if exists {
-		// Update existing group
-		logging.LDAPProtocolLogger.Trace("Updating group: %s", dn)
-		return c.ModifyEntry(dn, attrs, ldap.ReplaceAttribute)
-	} else {
-		// Create new group
-		logging.LDAPProtocolLogger.Trace("Creating group: %s", dn)
-		return c.CreateEntry(dn, attrs)
-	}
+// PrepareConfig fills in allocated POSIX numbers and user private groups.
+// Syncs do this themselves; commands that work on a single entity call it first.
+// Allocated numbers are not recorded in the state file; only syncs and applied plans record them.
+func (c *Client) PrepareConfig() error {
+	_, err := prepareConfig(c, c.config)
+	return err
 }
 
 // SyncAll synchronizes all configured entities with LDAP using a DAG approach
 func (c *Client) SyncAll() error {
-	// Ensure all required OUs exist
-	err := c.EnsureManagedOUsExist()
+	// Determine what needs to be added, modified, and deleted
+	cls, err := classifySync(c, c.config)
 	if err != nil {
 		return err
 	}
 
-	// First, get existing entities to determine what needs to be added, modified, and deleted
-	existingPeople, err := c.GetExistingEntries(c.config.LDAPEnforcer.EnforcedPeopleOU, "person")
+	// Check the deletion limits before making any change
+	err = checkDeletionLimits(c.config, cls.deletions(c), cls.existingCount)
 	if err != nil {
-		return fmt.Errorf("failed to get existing people: %w", err)
+		return err
 	}
 
-	existingSvcAccts, err := c.GetExistingEntries(c.config.LDAPEnforcer.EnforcedSvcAcctOU, "svcacct")
+	// Record allocated POSIX numbers and the attribute names about to be written before any entry uses them
+	err = recordPosixAllocations(c.config, cls.posixAllocations)
 	if err != nil {
-		return fmt.Errorf("failed to get existing service accounts: %w", err)
+		return err
 	}
-
-	existingGroups, err := c.GetExistingEntries(c.config.LDAPEnforcer.EnforcedGroupOU, "group")
+	err = recordWrittenState(c, c.config)
 	if err != nil {
-		return fmt.Errorf("failed to get existing groups: %w", err)
-	}
-
-	// Build a DAG of all entities to determine proper operation order
-	peopleToAdd := make(map[string]*model.Person)
-	peopleToModify := make(map[string]*model.Person)
-	peopleToDelete := make(map[string]string) // DN as value
-
-	svcAcctsToAdd := make(map[string]*model.SvcAcct)
-	svcAcctsToModify := make(map[string]*model.SvcAcct)
-	svcAcctsToDelete := make(map[string]string) // DN as value
-
-	groupsToAdd := make(map[string]*model.Group)
-	groupsToModify := make(map[string]*model.Group)
-	groupsToDelete := make(map[string]string) // DN as value
-
-	// Determine people and service accounts to add, modify, or delete
-	for uid, person := range c.config.LDAPEnforcer.Person {
-		dn := c.PersonToDN(uid)
-		if _, exists := existingPeople[dn]; exists {
-			peopleToModify[uid] = person
-			delete(existingPeople, dn) // Remove from existing so we know what to delete
-		} else {
-			peopleToAdd[uid] = person
-		}
-	}
-
-	for uid, svcacct := range c.config.LDAPEnforcer.SvcAcct {
-		dn := c.SvcAcctToDN(uid)
-		if _, exists := existingSvcAccts[dn]; exists {
-			svcAcctsToModify[uid] = svcacct
-			delete(existingSvcAccts, dn) // Remove from existing so we know what to delete
-		} else {
-			svcAcctsToAdd[uid] = svcacct
-		}
-	}
-
-	// Any remaining entries in existingPeople/existingSvcAccts are not in config and should be deleted
-	for dn := range existingPeople {
-		peopleToDelete[dn] = dn
-	}
-	for dn := range existingSvcAccts {
-		svcAcctsToDelete[dn] = dn
+		return err
 	}
 
-	// Build the group dependency graph
-	// Keep track of processed groups to avoid cycles
-	processedGroups := make(map[string]bool)
-	groupDeps := make(map[string][]string)           // Map of groupname -> groups it depends on
-	unresolvableMembers := make(map[string][]string) // Map of groupname -> unresolvable member UIDs
-
-	// Process all groups to determine dependencies and operation type
-	for groupname, group := range c.config.LDAPEnforcer.Group {
-		dn := c.GroupToDN(groupname)
-		if _, exists := existingGroups[dn]; exists {
-			groupsToModify[groupname] = group
-			delete(existingGroups, dn) // Remove from existing so we know what to delete
-		} else {
-			groupsToAdd[groupname] = group
-		}
-
-		// Collect dependencies for this group
-		deps, unresMem := c.getGroupDependencies(groupname, processedGroups)
-		if len(deps) > 0 {
-			groupDeps[groupname] = deps
-		}
-		if len(unresMem) > 0 {
-			unresolvableMembers[groupname] = unresMem
-		}
+	// Ensure all required OUs exist
+	err = c.EnsureManagedOUsExist()
+	if err != nil {
+		return err
 	}
 
-	// Any remaining entries in existingGroups are not in config and should be deleted
-	for dn := range existingGroups {
-		groupsToDelete[dn] = dn
-	}
+	return executeSync(c, cls, c.config.LDAPEnforcer.ContinueOnError)
+}
 
-	// Log any unresolvable members
-	for groupname, members := range unresolvableMembers {
-		for _, member := range members {
-			logging.DefaultLogger.Warn("Unresolvable member %s in group %s", member, groupname)
-		}
-	}
+// executeSync applies a classification in the correct order:
+// people and service accounts, then groups (leaf groups first), then deletions.
+// With continueOnError, failures are collected into a *SyncErrors instead of stopping the sync,
+// and groups with a member that failed to be created are skipped.
+func executeSync(c LDAPClientInterface, cls *syncClassification, continueOnError bool) error {
+	run := newSyncRun(continueOnError)
 
-	// Execute operations in the correct order:
 	// 1. First add and modify people and service accounts
-	for uid, person := range peopleToAdd {
+	for uid, person := range cls.peopleToAdd {
 		err := c.SyncPerson(uid, person)
 		if err != nil {
-			return fmt.Errorf("failed to add person %s: %w", uid, err)
+			if err := run.fail("person", uid, c.PersonToDN(uid), true, fmt.Errorf("failed to add person %s: %w", uid, err)); err != nil {
+				return err
+			}
 		}
 	}
 
-	for uid, person := range peopleToModify {
+	for uid, person := range cls.peopleToModify {
 		err := c.SyncPerson(uid, person)
 		if err != nil {
-			return fmt.Errorf("failed to modify person %s: %w", uid, err)
+			if err := run.fail("person", uid, c.PersonToDN(uid), false, fmt.Errorf("failed to modify person %s: %w", uid, err)); err != nil {
+				return err
+			}
 		}
 	}
 
-	for uid, svcacct := range svcAcctsToAdd {
+	for uid, svcacct := range cls.svcAcctsToAdd {
 		err := c.SyncSvcAcct(uid, svcacct)
 		if err != nil {
-			return fmt.Errorf("failed to add service account %s: %w", uid, err)
+			if err := run.fail("svcacct", uid, c.SvcAcctToDN(uid), true, fmt.Errorf("failed to add service account %s: %w", uid, err)); err != nil {
+				return err
+			}
 		}
 	}
 
-	for uid, svcacct := range svcAcctsToModify {
+	for uid, svcacct := range cls.svcAcctsToModify {
 		err := c.SyncSvcAcct(uid, svcacct)
 		if err != nil {
-			return fmt.Errorf("failed to modify service account %s: %w", uid, err)
+			if err := run.fail("svcacct", uid, c.SvcAcctToDN(uid), false, fmt.Errorf("failed to modify service account %s: %w", uid, err)); err != nil {
+				return err
+			}
 		}
 	}
 
 	// 2. Then add and modify groups in dependency order (leaf groups first)
-	// Get groups in order
-	groupOrder := c.topologicalSortGroups(groupDeps)
-
-	// Add and modify groups in order (leaf groups first)
-	for _, groupname := range groupOrder {
+	for _, groupname := range cls.groupOrder {
 		// Check if it's in our add or modify list
-		if group, ok := groupsToAdd[groupname]; ok {
-			err := c.SyncGroup(groupname, group)
-			if err != nil {
-				return fmt.Errorf("failed to add group %s: %w", groupname, err)
+		group, adding := cls.groupsToAdd[groupname]
+		if !adding {
+			var ok bool
+			group, ok = cls.groupsToModify[groupname]
+			if !ok {
+				continue
+			}
+		}
+		dn := c.GroupToDN(groupname)
+
+		// Skip groups that would reference a member that failed to be created
+		if len(run.missing) > 0 {
+			attrs, err := c.GetGroupAttributes(groupname, group)
+			if err == nil {
+				if member := run.missingMember(attrs["member"]); member != "" {
+					run.skip("group", groupname, dn, adding, fmt.Errorf("skipped group %s because member %s failed to sync", groupname, member))
+					continue
+				}
+			}
+		}
+
+		err := c.SyncGroup(groupname, group)
+		if err != nil {
+			if adding {
+				err = fmt.Errorf("failed to add group %s: %w", groupname, err)
+			} else {
+				err = fmt.Errorf("failed to modify group %s: %w", groupname, err)
 			}
-		} else if group, ok := groupsToModify[groupname]; ok {
-			err := c.SyncGroup(groupname, group)
-			if err != nil {
-				return fmt.Errorf("failed to modify group %s: %w", groupname, err)
+			if err := run.fail("group", groupname, dn, adding, err); err != nil {
+				return err
 			}
 		}
 	}
 
-	// 3. Finally delete entities (groups first)
-	for _, dn := range groupsToDelete {
+	// 3. Finally delete entities (groups first), quarantining people and service accounts if configured
+	for _, dn := range cls.groupsToDelete {
 		err := c.DeleteEntry(dn)
 		if err != nil {
-			return fmt.Errorf("failed to delete group %s: %w", dn, err)
+			if err := run.fail("group", dn, dn, false, fmt.Errorf("failed to delete group %s: %w", dn, err)); err != nil {
+				return err
+			}
 		}
 	}
 
-	for _, dn := range peopleToDelete {
-		err := c.DeleteEntry(dn)
+	for _, dn := range cls.peopleToDelete {
+		err := removeEntry(c, "person", dn)
 		if err != nil {
-			return fmt.Errorf("failed to delete person %s: %w", dn, err)
+			if err := run.fail("person", dn, dn, false, fmt.Errorf("failed to delete person %s: %w", dn, err)); err != nil {
+				return err
+			}
 		}
 	}
 
-	for _, dn := range svcAcctsToDelete {
-		err := c.DeleteEntry(dn)
+	for _, dn := range cls.svcAcctsToDelete {
+		err := removeEntry(c, "svcacct", dn)
 		if err != nil {
-			return fmt.Errorf("failed to delete service account %s: %w", dn, err)
+			if err := run.fail("svcacct", dn, dn, false, fmt.Errorf("failed to delete service account %s: %w", dn, err)); err != nil {
+				return err
+			}
 		}
 	}
 
-	return nil
+	// 4. Purge quarantined entries past the retention period
+	for _, change := range cls.quarantineToPurge {
+		err := applyChange(c, change)
+		if err != nil {
+			if err := run.fail(change.EntityType, change.ID, change.DN, false, fmt.Errorf("failed to purge quarantined %s %s: %w", change.EntityType, change.DN, err)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return run.err()
+}
+
+// removeEntry deletes or quarantines a person or service account that is no longer in the configuration
+func removeEntry(c LDAPClientInterface, entityType, dn string) error {
+	change, err := removalChange(c, entityType, dn)
+	if err != nil {
+		return err
+	}
+	return applyChange(c, change)
 }
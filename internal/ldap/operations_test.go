@@ -1,6 +1,7 @@
 package ldap
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 
@@ -9,12 +10,14 @@ import (
 )
 
 func TestGetPersonAttributes(t *testing.T) {
+	mockClient := NewMockClient(&config.Config{})
+
 	// Test a minimal person
 	minimalPerson := &model.Person{
 		Username: "jdoe",
 		CN:       "John Doe",
 	}
-	minAttrs := GetPersonAttributes(minimalPerson)
+	minAttrs := mockClient.GetPersonAttributes(minimalPerson)
 
 	if minAttrs["cn"] == nil || minAttrs["cn"][0] != "John Doe" {
 		t.Errorf("Expected CN attribute to be 'John Doe', got %v", minAttrs["cn"])
@@ -39,7 +42,7 @@ func TestGetPersonAttributes(t *testing.T) {
 		Mail:      "jane.smith@example.com",
 		Posix:     []int{1001, 1001},
 	}
-	fullAttrs := GetPersonAttributes(fullPerson)
+	fullAttrs := mockClient.GetPersonAttributes(fullPerson)
 
 	if fullAttrs["cn"] == nil || fullAttrs["cn"][0] != "Jane Smith" {
 		t.Errorf("Expected CN attribute to be 'Jane Smith', got %v", fullAttrs["cn"])
@@ -75,16 +78,87 @@ func TestGetPersonAttributes(t *testing.T) {
 	if fullAttrs["homeDirectory"] == nil || fullAttrs["homeDirectory"][0] != "/home/jsmith" {
 		t.Errorf("Expected homeDirectory to be '/home/jsmith', got %v", fullAttrs["homeDirectory"])
 	}
+
+	if fullAttrs["loginShell"] == nil || fullAttrs["loginShell"][0] != "/bin/bash" {
+		t.Errorf("Expected loginShell to be '/bin/bash', got %v", fullAttrs["loginShell"])
+	}
+}
+
+func TestGetAttributesPosixDefaults(t *testing.T) {
+	mockClient := NewMockClient(&config.Config{
+		LDAPEnforcer: config.LDAPEnforcerConfig{
+			DefaultLoginShell: "/bin/zsh",
+			SvcAcctLoginShell: "/bin/false",
+			HomeDirectoryBase: "/srv/home/",
+		},
+	})
+
+	tests := []struct {
+		name          string
+		attrs         map[string][]string
+		homeDirectory string
+		loginShell    string
+	}{
+		{
+			name:          "person with global defaults",
+			attrs:         mockClient.GetPersonAttributes(&model.Person{Username: "jdoe", CN: "John Doe", Posix: []int{1001, 1001}}),
+			homeDirectory: "/srv/home/jdoe",
+			loginShell:    "/bin/zsh",
+		},
+		{
+			name: "person with overrides",
+			attrs: mockClient.GetPersonAttributes(&model.Person{
+				Username:      "jdoe",
+				CN:            "John Doe",
+				Posix:         []int{1001, 1001},
+				LoginShell:    "/usr/bin/fish",
+				HomeDirectory: "/data/jdoe",
+			}),
+			homeDirectory: "/data/jdoe",
+			loginShell:    "/usr/bin/fish",
+		},
+		{
+			name:          "service account with global defaults",
+			attrs:         mockClient.GetSvcAcctAttributes(&model.SvcAcct{Username: "backup", CN: "Backup", Posix: []int{1050, 1050}}),
+			homeDirectory: "/srv/home/backup",
+			loginShell:    "/bin/false",
+		},
+		{
+			name: "service account with overrides",
+			attrs: mockClient.GetSvcAcctAttributes(&model.SvcAcct{
+				Username:      "backup",
+				CN:            "Backup",
+				Posix:         []int{1050, 1050},
+				LoginShell:    "/bin/sh",
+				HomeDirectory: "/var/lib/backup",
+			}),
+			homeDirectory: "/var/lib/backup",
+			loginShell:    "/bin/sh",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.attrs["homeDirectory"], []string{tt.homeDirectory}) {
+				t.Errorf("Expected homeDirectory %q, got %v", tt.homeDirectory, tt.attrs["homeDirectory"])
+			}
+			if !reflect.DeepEqual(tt.attrs["loginShell"], []string{tt.loginShell}) {
+				t.Errorf("Expected loginShell %q, got %v", tt.loginShell, tt.attrs["loginShell"])
+			}
+		})
+	}
 }
 
 func TestGetSvcAcctAttributes(t *testing.T) {
+	mockClient := NewMockClient(&config.Config{})
+
 	// Test a minimal service account
 	minimalSvcAcct := &model.SvcAcct{
 		Username:    "backup",
 		CN:          "Backup Service",
 		Description: "Service for backups",
 	}
-	minAttrs := GetSvcAcctAttributes(minimalSvcAcct)
+	minAttrs := mockClient.GetSvcAcctAttributes(minimalSvcAcct)
 
 	if minAttrs["cn"] == nil || minAttrs["cn"][0] != "Backup Service" {
 		t.Errorf("Expected CN attribute to be 'Backup Service', got %v", minAttrs["cn"])
@@ -113,7 +187,7 @@ func TestGetSvcAcctAttributes(t *testing.T) {
 		Mail:        "auth@example.com",
 		Posix:       []int{1050, 1051},
 	}
-	fullAttrs := GetSvcAcctAttributes(fullSvcAcct)
+	fullAttrs := mockClient.GetSvcAcctAttributes(fullSvcAcct)
 
 	if fullAttrs["cn"] == nil || fullAttrs["cn"][0] != "Auth Service" {
 		t.Errorf("Expected CN attribute to be 'Auth Service', got %v", fullAttrs["cn"])
@@ -228,3 +302,215 @@ func TestGetGroupAttributes(t *testing.T) {
 		t.Fatalf("Expected 3 members, got %d", len(allAttrs["member"]))
 	}
 }
+
+func TestGetGroupAttributesMemberUid(t *testing.T) {
+	testConfig := &config.Config{
+		LDAPEnforcer: config.LDAPEnforcerConfig{
+			EnforcedPeopleOU:  "ou=managed,ou=people,dc=example,dc=com",
+			EnforcedSvcAcctOU: "ou=managed,ou=svcaccts,dc=example,dc=com",
+			EnforcedGroupOU:   "ou=managed,ou=groups,dc=example,dc=com",
+			Person: map[string]*model.Person{
+				"john": {CN: "John Doe", Posix: []int{1001, 1001}},
+				"jane": {CN: "Jane Smith"},
+			},
+			SvcAcct: map[string]*model.SvcAcct{
+				"backup": {CN: "Backup Service", Description: "Backup service", Posix: []int{1050, 1050}},
+			},
+			Group: map[string]*model.Group{
+				"admins": {
+					Description:    "Administrators",
+					PosixGidNumber: 10100,
+					People:         []string{"john", "jane"},
+					SvcAccts:       []string{"backup"},
+				},
+				"all": {
+					Description:    "All users",
+					PosixGidNumber: 10101,
+					People:         []string{"john"},
+					Groups:         []string{"admins"},
+				},
+				"plain": {
+					Description: "Not a POSIX group",
+					People:      []string{"john"},
+				},
+				"nonposix": {
+					Description:    "POSIX group without POSIX members",
+					PosixGidNumber: 10102,
+					People:         []string{"jane"},
+				},
+			},
+		},
+	}
+	mockClient := NewMockClient(testConfig)
+
+	tests := []struct {
+		group      string
+		memberUIDs []string
+	}{
+		// Only POSIX members get a memberUid
+		{"admins", []string{"john", "backup"}},
+		// Members of nested groups are included once
+		{"all", []string{"john", "backup"}},
+		// Only POSIX groups get memberUid
+		{"plain", nil},
+		{"nonposix", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.group, func(t *testing.T) {
+			attrs, err := mockClient.GetGroupAttributes(tt.group, testConfig.LDAPEnforcer.Group[tt.group])
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(attrs["memberUid"], tt.memberUIDs) {
+				t.Errorf("Expected memberUid %v, got %v", tt.memberUIDs, attrs["memberUid"])
+			}
+		})
+	}
+}
+
+func TestSyncChangeOnlyWritesDifferences(t *testing.T) {
+	testConfig := &config.Config{
+		LDAPEnforcer: config.LDAPEnforcerConfig{
+			EnforcedPeopleOU: "ou=people,dc=example,dc=com",
+		},
+	}
+	mockClient := NewMockClient(testConfig)
+
+	person := &model.Person{CN: "John Doe", Mail: "john@example.com", Posix: []int{1001, 1001}}
+	dn := mockClient.PersonToDN("john")
+
+	// Existing entry matches the config, but with objectClass values in a different order
+	existing := mockClient.GetPersonAttributes(&model.Person{Username: "john", CN: "John Doe", Mail: "john@example.com", Posix: []int{1001, 1001}})
+	existing["objectClass"] = []string{"posixAccount", "nsMemberOf", "inetOrgPerson", "top"}
+	existing["uid"] = []string{"john"}
+	mockClient.Existing[dn] = true
+	mockClient.Entries[dn] = existing
+
+	change, err := planPerson(mockClient, "john", person)
+	if err != nil {
+		t.Fatalf("planPerson failed: %v", err)
+	}
+	if change.Action != ChangeUnchanged {
+		t.Fatalf("Expected person to be unchanged, got %s with %+v", change.Action, change.Attributes)
+	}
+	if err := syncChange(mockClient, change); err != nil {
+		t.Fatalf("syncChange failed: %v", err)
+	}
+	if len(mockClient.Operations) != 0 {
+		t.Errorf("Expected no LDAP writes for an unchanged person, got %+v", mockClient.Operations)
+	}
+
+	// Changing one attribute should only modify that attribute
+	person.Mail = "jdoe@example.com"
+	change, err = planPerson(mockClient, "john", person)
+	if err != nil {
+		t.Fatalf("planPerson failed: %v", err)
+	}
+	if change.Action != ChangeModify || len(change.Attributes) != 1 || change.Attributes[0].Name != "mail" {
+		t.Fatalf("Expected only mail to be modified, got %s with %+v", change.Action, change.Attributes)
+	}
+	if err := syncChange(mockClient, change); err != nil {
+		t.Fatalf("syncChange failed: %v", err)
+	}
+	if len(mockClient.Operations) != 1 || mockClient.Operations[0].OpType != "modify" {
+		t.Errorf("Expected a single modify operation, got %+v", mockClient.Operations)
+	}
+	if got := mockClient.Entries[dn]["mail"]; len(got) != 1 || got[0] != "jdoe@example.com" {
+		t.Errorf("Expected mail to be updated, got %v", got)
+	}
+}
+
+func TestSyncAllContinueOnError(t *testing.T) {
+	newConfig := func() *config.Config {
+		testConfig := newPlanTestConfig()
+		testConfig.LDAPEnforcer.Group["samegroup"] = &model.Group{
+			Description: "Same Group",
+			People:      []string{"sameuser"},
+		}
+		return testConfig
+	}
+
+	// Without continue-on-error, the first failure stops the sync
+	mockClient := NewMockClient(newConfig())
+	mockClient.Failures[mockClient.PersonToDN("newuser")] = errors.New("schema violation")
+	if err := mockClient.SyncAll(); err == nil {
+		t.Fatal("Expected SyncAll to fail")
+	} else if errors.As(err, new(*SyncErrors)) {
+		t.Errorf("Expected a plain error without continue-on-error, got %v", err)
+	}
+
+	testConfig := newConfig()
+	testConfig.LDAPEnforcer.ContinueOnError = true
+	mockClient = NewMockClient(testConfig)
+	mockClient.Failures[mockClient.PersonToDN("newuser")] = errors.New("schema violation")
+
+	err := mockClient.SyncAll()
+	var syncErrs *SyncErrors
+	if !errors.As(err, &syncErrs) {
+		t.Fatalf("Expected *SyncErrors, got %v", err)
+	}
+
+	// newuser failed, and testgroup (which contains newuser) was skipped
+	results := make(map[string]*EntityError)
+	for _, entityErr := range syncErrs.Errors {
+		results[entityErr.EntityType+"/"+entityErr.ID] = entityErr
+	}
+	if len(results) != 2 {
+		t.Errorf("Expected 2 errors, got %v", err)
+	}
+	if e := results["person/newuser"]; e == nil || e.Skipped {
+		t.Errorf("Expected newuser to have failed, got %+v", e)
+	}
+	if e := results["group/testgroup"]; e == nil || !e.Skipped {
+		t.Errorf("Expected testgroup to be skipped, got %+v", e)
+	}
+
+	// Independent entities were still synced
+	for _, dn := range []string{
+		mockClient.PersonToDN("sameuser"),
+		mockClient.PersonToDN("moduser"),
+		mockClient.GroupToDN("samegroup"),
+	} {
+		if !mockClient.Existing[dn] {
+			t.Errorf("Expected %s to be created", dn)
+		}
+	}
+	if mockClient.Existing[mockClient.GroupToDN("testgroup")] {
+		t.Error("Expected testgroup not to be created")
+	}
+}
+
+func TestGetGroupAttributesAllowEmpty(t *testing.T) {
+	testConfig := &config.Config{
+		LDAPEnforcer: config.LDAPEnforcerConfig{
+			EnforcedGroupOU: "ou=managed,ou=groups,dc=example,dc=com",
+			Group: map[string]*model.Group{
+				"empty":   {Description: "No members"},
+				"allowed": {Description: "No members, kept anyway", AllowEmpty: true},
+			},
+		},
+	}
+	mockClient := NewMockClient(testConfig)
+
+	if _, err := mockClient.GetGroupAttributes("empty", testConfig.LDAPEnforcer.Group["empty"]); !errors.Is(err, ErrGroupHasNoMembers) {
+		t.Errorf("Expected ErrGroupHasNoMembers, got %v", err)
+	}
+
+	attrs, err := mockClient.GetGroupAttributes("allowed", testConfig.LDAPEnforcer.Group["allowed"])
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(attrs["member"], []string{config.DefaultEmptyGroupPlaceholder}) {
+		t.Errorf("Expected the default placeholder member, got %v", attrs["member"])
+	}
+
+	testConfig.LDAPEnforcer.EmptyGroupPlaceholder = "cn=placeholder,dc=example,dc=com"
+	attrs, err = mockClient.GetGroupAttributes("allowed", testConfig.LDAPEnforcer.Group["allowed"])
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(attrs["member"], []string{"cn=placeholder,dc=example,dc=com"}) {
+		t.Errorf("Expected the configured placeholder member, got %v", attrs["member"])
+	}
+}
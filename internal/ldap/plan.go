@@ -0,0 +1,741 @@
+package ldap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/mrled/ldapenforcer/internal/config"
+	"github.com/mrled/ldapenforcer/internal/logging"
+	"github.com/mrled/ldapenforcer/internal/model"
+)
+
+// ChangeType is the kind of change a sync would make to a single entry
+type ChangeType string
+
+// Change types
+const (
+	// ChangeCreate means the entry does not exist and would be created
+	ChangeCreate ChangeType = "create"
+	// ChangeModify means the entry exists and some attributes differ from the config
+	ChangeModify ChangeType = "modify"
+	// ChangeDelete means the entry exists but is not in the config
+	ChangeDelete ChangeType = "delete"
+	// ChangeUnchanged means the entry exists and already matches the config
+	ChangeUnchanged ChangeType = "unchanged"
+	// ChangeQuarantine means the entry is not in the config and would be moved to the quarantine OU and locked
+	ChangeQuarantine ChangeType = "quarantine"
+	// ChangeRestore means the entry is back in the config and would be moved out of the quarantine OU
+	ChangeRestore ChangeType = "restore"
+	// ChangePurge means the entry has been quarantined for longer than the retention period and would be deleted
+	ChangePurge ChangeType = "purge"
+)
+
+// AttributeChange describes the old and new values of a single attribute
+type AttributeChange struct {
+	// Name of the attribute
+	Name string `json:"name"`
+
+	// Values currently in LDAP (empty when the attribute is not set)
+	Old []string `json:"old,omitempty"`
+
+	// Values from the configuration (empty when the attribute should be removed)
+	New []string `json:"new,omitempty"`
+}
+
+// EntityChange describes the change a sync would make to a single entry
+type EntityChange struct {
+	// Action is what would be done to the entry
+	Action ChangeType `json:"action"`
+
+	// EntityType is the type of entity (person, svcacct, group)
+	EntityType string `json:"entity_type"`
+
+	// ID is the uid or group name of the entity
+	ID string `json:"id"`
+
+	// DN is the distinguished name of the entry
+	DN string `json:"dn"`
+
+	// NewSuperior is the OU the entry would be moved to before its attributes are changed (empty if it is not moved)
+	NewSuperior string `json:"new_superior,omitempty"`
+
+	// Attributes lists the attributes that would be set or changed
+	Attributes []AttributeChange `json:"attributes,omitempty"`
+
+	// Fingerprint of the entry in LDAP when the plan was made (empty if it did not exist)
+	Fingerprint string `json:"fingerprint"`
+}
+
+// TargetDN returns the DN of the entry after the change is applied
+func (e *EntityChange) TargetDN() string {
+	if e.NewSuperior == "" {
+		return e.DN
+	}
+	rdn, _ := splitDN(e.DN)
+	return rdn + "," + e.NewSuperior
+}
+
+// PlanFormatVersion is the version of the plan file format
+const PlanFormatVersion = 1
+
+// SyncPlan is an ordered list of the changes a sync would make
+type SyncPlan struct {
+	// FormatVersion of the plan file
+	FormatVersion int `json:"format_version"`
+
+	// CreatedAt is when the plan was made
+	CreatedAt time.Time `json:"created_at"`
+
+	// ConfigHash is the hash of the configuration the plan was made from
+	ConfigHash string `json:"config_hash"`
+
+	// PosixAllocations are the POSIX numbers allocated for the plan, recorded in the state file when it is applied
+	PosixAllocations *posixState `json:"posix_allocations,omitempty"`
+
+	// MissingOUs are the managed OUs that do not exist yet and would be created before any entry
+	MissingOUs []string `json:"missing_ous,omitempty"`
+
+	// Changes in the order they would be applied
+	Changes []*EntityChange `json:"changes"`
+}
+
+// WritePlanFile writes the plan to a JSON file, or to stdout if path is "-"
+func (p *SyncPlan) WritePlanFile(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(path, data, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write plan file %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadPlanFile reads a plan from a JSON file
+func ReadPlanFile(path string) (*SyncPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan file %s: %w", path, err)
+	}
+
+	var plan SyncPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to decode plan file %s: %w", path, err)
+	}
+	if plan.FormatVersion != PlanFormatVersion {
+		return nil, fmt.Errorf("unsupported plan format version %d in %s (expected %d)", plan.FormatVersion, path, PlanFormatVersion)
+	}
+
+	return &plan, nil
+}
+
+// Count returns the number of changes in the plan with the given action
+func (p *SyncPlan) Count(action ChangeType) int {
+	count := 0
+	for _, change := range p.Changes {
+		if change.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// HasChanges returns true if the plan would make any change to LDAP
+func (p *SyncPlan) HasChanges() bool {
+	for _, change := range p.Changes {
+		if change.Action != ChangeUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+// syncClassification holds the entities a sync would add, modify, and delete
+type syncClassification struct {
+	peopleToAdd    map[string]*model.Person
+	peopleToModify map[string]*model.Person
+	peopleToDelete map[string]string // DN as value
+
+	svcAcctsToAdd    map[string]*model.SvcAcct
+	svcAcctsToModify map[string]*model.SvcAcct
+	svcAcctsToDelete map[string]string // DN as value
+
+	groupsToAdd    map[string]*model.Group
+	groupsToModify map[string]*model.Group
+	groupsToDelete map[string]string // DN as value
+
+	// Group names in dependency order (leaf groups first)
+	groupOrder []string
+
+	// Quarantined entries that are older than the retention period
+	quarantineToPurge []*EntityChange
+
+	// Number of entries that currently exist in the enforced OUs
+	existingCount int
+
+	// POSIX numbers allocated for this sync that are not recorded in the state file yet
+	posixAllocations *posixState
+}
+
+// prepareConfig fills in what the configuration leaves to the enforcer:
+// usernames, POSIX numbers for entities with auto_posix, then the GID numbers of accounts with a primary group,
+// then the user private groups, which need the GID numbers.
+// It returns the newly allocated POSIX numbers, which the caller records if it makes changes.
+func prepareConfig(c LDAPClientInterface, cfg *config.Config) (*posixState, error) {
+	// Set the Username fields with their uids, so that the prepared config hashes the same however it was built
+	for uid, person := range cfg.LDAPEnforcer.Person {
+		if person.Username == "" {
+			person.Username = uid
+		}
+	}
+	for uid, svcacct := range cfg.LDAPEnforcer.SvcAcct {
+		if svcacct.Username == "" {
+			svcacct.Username = uid
+		}
+	}
+
+	allocated, err := allocatePosixIDs(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ResolvePrimaryGroups()
+
+	// Attributes and locks that earlier syncs wrote stay managed after the last entity stops setting them
+	state, err := loadPosixState(cfg.GetPosixStateFile())
+	if err != nil {
+		return nil, err
+	}
+	c.setWrittenState(state)
+
+	// Replace the private groups from an earlier run, whose people may have been removed since
+	for groupname, group := range cfg.LDAPEnforcer.Group {
+		if group.Private {
+			delete(cfg.LDAPEnforcer.Group, groupname)
+		}
+	}
+	privateGroups, err := cfg.PrivateGroups()
+	if err != nil {
+		return nil, err
+	}
+	if len(privateGroups) > 0 && cfg.LDAPEnforcer.Group == nil {
+		cfg.LDAPEnforcer.Group = make(map[string]*model.Group)
+	}
+	for groupname, group := range privateGroups {
+		cfg.LDAPEnforcer.Group[groupname] = group
+	}
+	return allocated, nil
+}
+
+// classifySync compares the configuration to the entries in the enforced OUs
+// and determines which entities need to be added, modified, and deleted.
+// It is shared by SyncAll and PlanSync so that a preview and a real run agree.
+func classifySync(c LDAPClientInterface, cfg *config.Config) (*syncClassification, error) {
+	allocated, err := prepareConfig(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// First, get existing entities to determine what needs to be added, modified, and deleted
+	existingPeople, err := c.GetExistingEntries(cfg.LDAPEnforcer.EnforcedPeopleOU, "person")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing people: %w", err)
+	}
+
+	existingSvcAccts, err := c.GetExistingEntries(cfg.LDAPEnforcer.EnforcedSvcAcctOU, "svcacct")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing service accounts: %w", err)
+	}
+
+	existingGroups, err := c.GetExistingEntries(cfg.LDAPEnforcer.EnforcedGroupOU, "group")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing groups: %w", err)
+	}
+	if cfg.LDAPEnforcer.PrivateGroupOU != "" {
+		// The private group OU is enforced too, so private groups of removed people are deleted
+		existingPrivateGroups, err := c.GetExistingEntries(cfg.LDAPEnforcer.PrivateGroupOU, "group")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get existing private groups: %w", err)
+		}
+		for dn := range existingPrivateGroups {
+			existingGroups[dn] = dn
+		}
+	}
+
+	// Build a DAG of all entities to determine proper operation order
+	cls := &syncClassification{
+		existingCount:    len(existingPeople) + len(existingSvcAccts) + len(existingGroups),
+		peopleToAdd:      make(map[string]*model.Person),
+		peopleToModify:   make(map[string]*model.Person),
+		peopleToDelete:   make(map[string]string),
+		svcAcctsToAdd:    make(map[string]*model.SvcAcct),
+		svcAcctsToModify: make(map[string]*model.SvcAcct),
+		svcAcctsToDelete: make(map[string]string),
+		groupsToAdd:      make(map[string]*model.Group),
+		groupsToModify:   make(map[string]*model.Group),
+		groupsToDelete:   make(map[string]string),
+		posixAllocations: allocated,
+	}
+
+	// Determine people and service accounts to add, modify, or delete
+	for uid, person := range cfg.LDAPEnforcer.Person {
+		dn := c.PersonToDN(uid)
+		if _, exists := existingPeople[dn]; exists {
+			cls.peopleToModify[uid] = person
+			delete(existingPeople, dn) // Remove from existing so we know what to delete
+		} else {
+			cls.peopleToAdd[uid] = person
+		}
+	}
+
+	for uid, svcacct := range cfg.LDAPEnforcer.SvcAcct {
+		dn := c.SvcAcctToDN(uid)
+		if _, exists := existingSvcAccts[dn]; exists {
+			cls.svcAcctsToModify[uid] = svcacct
+			delete(existingSvcAccts, dn) // Remove from existing so we know what to delete
+		} else {
+			cls.svcAcctsToAdd[uid] = svcacct
+		}
+	}
+
+	// Any remaining entries in existingPeople/existingSvcAccts are not in config and should be deleted
+	for dn := range existingPeople {
+		cls.peopleToDelete[dn] = dn
+	}
+	for dn := range existingSvcAccts {
+		cls.svcAcctsToDelete[dn] = dn
+	}
+
+	// Build the group dependency graph
+	// Keep track of processed groups to avoid cycles
+	processedGroups := make(map[string]bool)
+	groupDeps := make(map[string][]string)           // Map of groupname -> groups it depends on
+	unresolvableMembers := make(map[string][]string) // Map of groupname -> unresolvable member UIDs
+
+	// Process all groups to determine dependencies and operation type
+	for groupname, group := range cfg.LDAPEnforcer.Group {
+		dn := c.GroupToDN(groupname)
+		if _, exists := existingGroups[dn]; exists {
+			cls.groupsToModify[groupname] = group
+			delete(existingGroups, dn) // Remove from existing so we know what to delete
+		} else {
+			cls.groupsToAdd[groupname] = group
+		}
+
+		// Collect dependencies for this group
+		deps, unresMem := c.getGroupDependencies(groupname, processedGroups)
+		if len(deps) > 0 {
+			groupDeps[groupname] = deps
+		}
+		if len(unresMem) > 0 {
+			unresolvableMembers[groupname] = unresMem
+		}
+	}
+
+	// Any remaining entries in existingGroups are not in config and should be deleted
+	for dn := range existingGroups {
+		cls.groupsToDelete[dn] = dn
+	}
+
+	// Log any unresolvable members
+	for groupname, members := range unresolvableMembers {
+		for _, member := range members {
+			logging.DefaultLogger.Warn("Unresolvable member %s in group %s", member, groupname)
+		}
+	}
+
+	// External members are never created, so warn about any that do not exist
+	checkedExternal := make(map[string]bool)
+	for _, groupname := range sortedKeys(cfg.LDAPEnforcer.Group) {
+		for _, dn := range cfg.LDAPEnforcer.Group[groupname].ExternalMembers {
+			if checkedExternal[strings.ToLower(dn)] {
+				continue
+			}
+			checkedExternal[strings.ToLower(dn)] = true
+			exists, err := c.EntryExists(dn)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check external member %s of group %s: %w", dn, groupname, err)
+			}
+			if !exists {
+				logging.DefaultLogger.Warn("External member %s of group %s does not exist", dn, groupname)
+			}
+		}
+	}
+
+	cls.groupOrder = c.topologicalSortGroups(groupDeps)
+
+	cls.quarantineToPurge, err = planQuarantinePurges(c, cfg, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return cls, nil
+}
+
+// buildSyncPlan computes the changes that SyncAll would make, in the order it would make them
+func buildSyncPlan(c LDAPClientInterface, cfg *config.Config) (*SyncPlan, error) {
+	cls, err := classifySync(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hash the configuration as prepared by classifySync, so that allocated POSIX numbers are included
+	configHash, err := cfg.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &SyncPlan{
+		FormatVersion: PlanFormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		ConfigHash:    configHash,
+	}
+	if !cls.posixAllocations.empty() {
+		plan.PosixAllocations = cls.posixAllocations
+	}
+	for _, ou := range managedOUs(cfg) {
+		exists, err := c.EntryExists(ou)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check whether OU %s exists: %w", ou, err)
+		}
+		if !exists {
+			plan.MissingOUs = append(plan.MissingOUs, ou)
+		}
+	}
+	addChange := func(change *EntityChange) {
+		if change != nil {
+			plan.Changes = append(plan.Changes, change)
+		}
+	}
+
+	// 1. People and service accounts
+	for _, uid := range sortedKeys(cls.peopleToAdd) {
+		change, err := planPerson(c, uid, cls.peopleToAdd[uid])
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan person %s: %w", uid, err)
+		}
+		addChange(change)
+	}
+	for _, uid := range sortedKeys(cls.peopleToModify) {
+		change, err := planPerson(c, uid, cls.peopleToModify[uid])
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan person %s: %w", uid, err)
+		}
+		addChange(change)
+	}
+	for _, uid := range sortedKeys(cls.svcAcctsToAdd) {
+		change, err := planSvcAcct(c, uid, cls.svcAcctsToAdd[uid])
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan service account %s: %w", uid, err)
+		}
+		addChange(change)
+	}
+	for _, uid := range sortedKeys(cls.svcAcctsToModify) {
+		change, err := planSvcAcct(c, uid, cls.svcAcctsToModify[uid])
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan service account %s: %w", uid, err)
+		}
+		addChange(change)
+	}
+
+	// 2. Groups in dependency order
+	for _, groupname := range cls.groupOrder {
+		group, ok := cls.groupsToAdd[groupname]
+		if !ok {
+			group, ok = cls.groupsToModify[groupname]
+		}
+		if !ok {
+			continue
+		}
+		change, err := planGroup(c, groupname, group)
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan group %s: %w", groupname, err)
+		}
+		addChange(change)
+	}
+
+	// 3. Deletions (groups first), quarantining people and service accounts if configured
+	deletions := []struct {
+		entityType string
+		dns        map[string]string
+	}{
+		{"group", cls.groupsToDelete},
+		{"person", cls.peopleToDelete},
+		{"svcacct", cls.svcAcctsToDelete},
+	}
+	for _, deletion := range deletions {
+		for _, dn := range sortedKeys(deletion.dns) {
+			change, err := removalChange(c, deletion.entityType, dn)
+			if err != nil {
+				return nil, fmt.Errorf("failed to plan deletion of %s: %w", dn, err)
+			}
+			addChange(change)
+		}
+	}
+
+	// 4. Quarantined entries past the retention period
+	for _, change := range cls.quarantineToPurge {
+		addChange(change)
+	}
+
+	return plan, nil
+}
+
+// managedOUs returns the OUs that EnsureManagedOUsExist creates, without duplicates
+func managedOUs(cfg *config.Config) []string {
+	candidates := []string{
+		cfg.LDAPEnforcer.EnforcedPeopleOU,
+		cfg.LDAPEnforcer.EnforcedSvcAcctOU,
+		cfg.LDAPEnforcer.EnforcedGroupOU,
+		cfg.LDAPEnforcer.PrivateGroupOU,
+		cfg.LDAPEnforcer.QuarantineOU,
+	}
+
+	var ous []string
+	seen := make(map[string]bool)
+	for _, ou := range candidates {
+		if ou == "" || seen[strings.ToLower(ou)] {
+			continue
+		}
+		seen[strings.ToLower(ou)] = true
+		ous = append(ous, ou)
+	}
+	return ous
+}
+
+// planPerson computes the change needed to make a person in LDAP match the configuration
+func planPerson(c LDAPClientInterface, uid string, person *model.Person) (*EntityChange, error) {
+	// Set the Username field with the uid if it's not already set
+	if person.Username == "" {
+		person.Username = uid
+	}
+	return planEntry(c, "person", uid, c.PersonToDN(uid), c.GetPersonAttributes(person))
+}
+
+// planSvcAcct computes the change needed to make a service account in LDAP match the configuration
+func planSvcAcct(c LDAPClientInterface, uid string, svcacct *model.SvcAcct) (*EntityChange, error) {
+	// Set the Username field with the uid if it's not already set
+	if svcacct.Username == "" {
+		svcacct.Username = uid
+	}
+	return planEntry(c, "svcacct", uid, c.SvcAcctToDN(uid), c.GetSvcAcctAttributes(svcacct))
+}
+
+// planGroup computes the change needed to make a group in LDAP match the configuration.
+// Memberless groups are deleted if they exist, and skipped (nil change) otherwise.
+func planGroup(c LDAPClientInterface, groupname string, group *model.Group) (*EntityChange, error) {
+	dn := c.GroupToDN(groupname)
+
+	attrs, err := c.GetGroupAttributes(groupname, group)
+	if errors.Is(err, ErrGroupHasNoMembers) {
+		exists, err := c.EntryExists(dn)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			logging.DefaultLogger.Warn("Group %s has no members and will be deleted (add at least one member)", groupname)
+			return deleteChange(c, "group", dn)
+		}
+		logging.DefaultLogger.Warn("Group %s has no members and will not be created (add at least one member)", groupname)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return planEntry(c, "group", groupname, dn, attrs)
+}
+
+// planEntry compares the desired attributes of an entry to what is currently in LDAP
+func planEntry(c LDAPClientInterface, entityType, id, dn string, desired map[string][]string) (*EntityChange, error) {
+	current, err := c.GetEntryAttributes(dn)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &EntityChange{
+		EntityType:  entityType,
+		ID:          id,
+		DN:          dn,
+		Fingerprint: fingerprintEntry(current),
+	}
+
+	// A removed person or service account that is back in the config is restored from quarantine
+	if current == nil && entityType != "group" {
+		if quarantineDN := c.QuarantineToDN(id); quarantineDN != "" {
+			quarantined, err := c.GetEntryAttributes(quarantineDN)
+			if err != nil {
+				return nil, err
+			}
+			if stampType, _, ok := parseQuarantineStamp(quarantined); ok && stampType == entityType {
+				return restoreChange(entityType, id, dn, quarantineDN, quarantined, desired, c.managedAttributes(entityType)), nil
+			}
+		}
+	}
+
+	if current == nil {
+		change.Action = ChangeCreate
+		// People and service accounts need the uid attribute on creation
+		if entityType != "group" {
+			desired["uid"] = []string{id}
+		}
+		for _, name := range sortedKeys(desired) {
+			change.Attributes = append(change.Attributes, AttributeChange{Name: name, New: desired[name]})
+		}
+		return change, nil
+	}
+
+	change.Attributes = diffAttributes(current, desired, c.managedAttributes(entityType))
+	if len(change.Attributes) == 0 {
+		change.Action = ChangeUnchanged
+	} else {
+		change.Action = ChangeModify
+	}
+	return change, nil
+}
+
+// deleteChange returns a delete change for an entry that is not in the configuration
+func deleteChange(c LDAPClientInterface, entityType, dn string) (*EntityChange, error) {
+	current, err := c.GetEntryAttributes(dn)
+	if err != nil {
+		return nil, err
+	}
+	return &EntityChange{
+		Action:      ChangeDelete,
+		EntityType:  entityType,
+		ID:          getRDNValue(dn),
+		DN:          dn,
+		Fingerprint: fingerprintEntry(current),
+	}, nil
+}
+
+// fingerprintEntry returns a stable hash of an entry's attributes,
+// or an empty string if the entry does not exist (nil map).
+// Attribute names are lowercased and values sorted so that ordering differences are ignored.
+func fingerprintEntry(attrs map[string][]string) string {
+	if attrs == nil {
+		return ""
+	}
+
+	normalized := make(map[string][]string, len(attrs))
+	for name, values := range attrs {
+		sortedValues := append([]string(nil), values...)
+		sort.Strings(sortedValues)
+		normalized[strings.ToLower(name)] = sortedValues
+	}
+
+	hash := sha256.New()
+	for _, name := range sortedKeys(normalized) {
+		fmt.Fprintf(hash, "%s\n", name)
+		for _, value := range normalized[name] {
+			fmt.Fprintf(hash, "\t%q\n", value)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// diffAttributes returns the attributes whose desired values differ from the current values,
+// plus removals for owned attributes that are set in LDAP but absent from the desired attributes.
+// Attribute names are compared case-insensitively, and value order is ignored.
+func diffAttributes(current, desired map[string][]string, owned []string) []AttributeChange {
+	currentByName := make(map[string][]string, len(current))
+	for name, values := range current {
+		currentByName[strings.ToLower(name)] = values
+	}
+	desiredByName := make(map[string]bool, len(desired))
+	for name := range desired {
+		desiredByName[strings.ToLower(name)] = true
+	}
+
+	var changes []AttributeChange
+	for _, name := range sortedKeys(desired) {
+		old := currentByName[strings.ToLower(name)]
+		if !sameAttributeValues(name, old, desired[name]) {
+			changes = append(changes, AttributeChange{Name: name, Old: old, New: desired[name]})
+		}
+	}
+
+	// Remove owned attributes that were dropped from the configuration
+	for _, name := range owned {
+		lower := strings.ToLower(name)
+		if desiredByName[lower] {
+			continue
+		}
+		if old := currentByName[lower]; len(old) > 0 {
+			changes = append(changes, AttributeChange{Name: name, Old: old})
+		}
+	}
+
+	return changes
+}
+
+// caseInsensitiveAttributes are attributes whose values are compared case-insensitively (lowercase)
+var caseInsensitiveAttributes = map[string]bool{
+	"nsaccountlock": true,
+}
+
+// sameAttributeValues returns true if both slices hold the same values of an attribute, ignoring order,
+// and ignoring case for attributes like nsAccountLock whose values are case-insensitive
+func sameAttributeValues(name string, a, b []string) bool {
+	if !caseInsensitiveAttributes[strings.ToLower(name)] {
+		return sameValues(a, b)
+	}
+	lower := func(values []string) []string {
+		result := make([]string, len(values))
+		for i, value := range values {
+			result[i] = strings.ToLower(value)
+		}
+		return result
+	}
+	return sameValues(lower(a), lower(b))
+}
+
+// sameValues returns true if both slices hold the same values, ignoring order
+func sameValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, v := range a {
+		counts[v]++
+	}
+	for _, v := range b {
+		if counts[v] == 0 {
+			return false
+		}
+		counts[v]--
+	}
+	return true
+}
+
+// getRDNValue returns the value of the first RDN of a DN, e.g. "john" for "uid=john,ou=people,..."
+func getRDNValue(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return ""
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
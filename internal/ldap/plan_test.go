@@ -0,0 +1,779 @@
+package ldap
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mrled/ldapenforcer/internal/config"
+	"github.com/mrled/ldapenforcer/internal/model"
+)
+
+func newPlanTestConfig() *config.Config {
+	return &config.Config{
+		LDAPEnforcer: config.LDAPEnforcerConfig{
+			EnforcedPeopleOU:  "ou=people,dc=example,dc=com",
+			EnforcedSvcAcctOU: "ou=svcaccts,dc=example,dc=com",
+			EnforcedGroupOU:   "ou=groups,dc=example,dc=com",
+			Person: map[string]*model.Person{
+				"newuser":  {CN: "New User"},
+				"sameuser": {CN: "Same User"},
+				"moduser":  {CN: "Modified User", Mail: "new@example.com"},
+			},
+			SvcAcct: map[string]*model.SvcAcct{},
+			Group: map[string]*model.Group{
+				"testgroup": {
+					Description: "Test Group",
+					People:      []string{"newuser", "sameuser"},
+				},
+			},
+		},
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	mockClient := NewMockClient(testConfig)
+
+	// sameuser already matches the config
+	sameDN := mockClient.PersonToDN("sameuser")
+	mockClient.Existing[sameDN] = true
+	mockClient.Entries[sameDN] = mockClient.GetPersonAttributes(&model.Person{Username: "sameuser", CN: "Same User"})
+
+	// moduser has an old mail address
+	modDN := mockClient.PersonToDN("moduser")
+	mockClient.Existing[modDN] = true
+	mockClient.Entries[modDN] = mockClient.GetPersonAttributes(&model.Person{Username: "moduser", CN: "Modified User", Mail: "old@example.com"})
+
+	// olduser is not in the config
+	oldDN := mockClient.PersonToDN("olduser")
+	mockClient.Existing[oldDN] = true
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	if len(mockClient.Operations) != 0 {
+		t.Errorf("PlanSync should not perform any operations, got %d", len(mockClient.Operations))
+	}
+
+	actions := make(map[string]ChangeType)
+	for _, change := range plan.Changes {
+		actions[change.DN] = change.Action
+	}
+
+	expected := map[string]ChangeType{
+		mockClient.PersonToDN("newuser"):  ChangeCreate,
+		sameDN:                            ChangeUnchanged,
+		modDN:                             ChangeModify,
+		oldDN:                             ChangeDelete,
+		mockClient.GroupToDN("testgroup"): ChangeCreate,
+	}
+	for dn, action := range expected {
+		if actions[dn] != action {
+			t.Errorf("Expected %s for %s, got %q", action, dn, actions[dn])
+		}
+	}
+
+	// The modify should only touch the mail attribute
+	for _, change := range plan.Changes {
+		if change.DN != modDN {
+			continue
+		}
+		if len(change.Attributes) != 1 || change.Attributes[0].Name != "mail" {
+			t.Fatalf("Expected only mail to change, got %+v", change.Attributes)
+		}
+		if change.Attributes[0].Old[0] != "old@example.com" || change.Attributes[0].New[0] != "new@example.com" {
+			t.Errorf("Unexpected mail change: %+v", change.Attributes[0])
+		}
+	}
+
+	if plan.Count(ChangeDelete) != 1 {
+		t.Errorf("Expected 1 delete, got %d", plan.Count(ChangeDelete))
+	}
+}
+
+func TestDiffAttributes(t *testing.T) {
+	current := map[string][]string{
+		"objectclass": {"posixAccount", "top", "inetOrgPerson"},
+		"cn":          {"John Doe"},
+		"mail":        {"old@example.com"},
+	}
+	desired := map[string][]string{
+		"objectClass": {"top", "inetOrgPerson", "posixAccount"},
+		"cn":          {"John Doe"},
+		"mail":        {"new@example.com"},
+		"givenName":   {"John"},
+	}
+
+	changes := diffAttributes(current, desired, nil)
+	changed := make(map[string]bool)
+	for _, change := range changes {
+		changed[change.Name] = true
+	}
+
+	if changed["objectClass"] {
+		t.Error("objectClass should be unchanged when only the value order differs")
+	}
+	if changed["cn"] {
+		t.Error("cn should be unchanged")
+	}
+	if !changed["mail"] {
+		t.Error("mail should be changed")
+	}
+	if !changed["givenName"] {
+		t.Error("givenName should be changed when it is not set in LDAP")
+	}
+}
+
+func TestApplyPlan(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	mockClient := NewMockClient(testConfig)
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	// Round-trip the plan through a file
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	if err := plan.WritePlanFile(planPath); err != nil {
+		t.Fatalf("WritePlanFile failed: %v", err)
+	}
+	plan, err = ReadPlanFile(planPath)
+	if err != nil {
+		t.Fatalf("ReadPlanFile failed: %v", err)
+	}
+
+	if err := mockClient.ApplyPlan(plan); err != nil {
+		t.Fatalf("ApplyPlan failed: %v", err)
+	}
+
+	// Everything in the config should now exist
+	for uid := range testConfig.LDAPEnforcer.Person {
+		if !mockClient.Existing[mockClient.PersonToDN(uid)] {
+			t.Errorf("Expected person %s to be created", uid)
+		}
+	}
+	if !mockClient.Existing[mockClient.GroupToDN("testgroup")] {
+		t.Error("Expected testgroup to be created")
+	}
+
+	// A fresh plan should have nothing to do
+	plan, err = mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if plan.HasChanges() {
+		t.Errorf("Expected no changes after applying the plan, got %+v", plan.Changes)
+	}
+}
+
+func TestApplyPlanRefusesDrift(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	mockClient := NewMockClient(testConfig)
+
+	modDN := mockClient.PersonToDN("moduser")
+	mockClient.Existing[modDN] = true
+	mockClient.Entries[modDN] = map[string][]string{"mail": {"old@example.com"}}
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	// Someone changes the entry after the plan was made
+	mockClient.Entries[modDN]["mail"] = []string{"other@example.com"}
+
+	if err := mockClient.ApplyPlan(plan); err == nil {
+		t.Fatal("Expected ApplyPlan to refuse a plan for a drifted entry")
+	}
+	if len(mockClient.Operations) != 0 {
+		t.Errorf("Expected no operations after drift was detected, got %d", len(mockClient.Operations))
+	}
+
+	// An entry that appeared in an enforced OU is also drift
+	mockClient.Entries[modDN]["mail"] = []string{"old@example.com"}
+	mockClient.Existing[mockClient.PersonToDN("intruder")] = true
+	if err := mockClient.ApplyPlan(plan); err == nil {
+		t.Fatal("Expected ApplyPlan to refuse a plan when a new entry appeared")
+	}
+}
+
+func TestApplyPlanRefusesConfigChange(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	mockClient := NewMockClient(testConfig)
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	// The plan no longer reflects the config once a global default changes
+	testConfig = newPlanTestConfig()
+	testConfig.LDAPEnforcer.DefaultLoginShell = "/bin/zsh"
+	mockClient.config = testConfig
+	if err := mockClient.ApplyPlan(plan); err == nil {
+		t.Fatal("Expected ApplyPlan to refuse a plan made from a different config")
+	}
+	if len(mockClient.Operations) != 0 {
+		t.Errorf("Expected no operations after the config change was detected, got %d", len(mockClient.Operations))
+	}
+
+	// The override applies the plan as reviewed
+	testConfig.LDAPEnforcer.AllowConfigChange = true
+	if err := mockClient.ApplyPlan(plan); err != nil {
+		t.Fatalf("ApplyPlan failed: %v", err)
+	}
+}
+
+func TestApplyPlanRefusesDriftInPrivateGroupOU(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	testConfig.LDAPEnforcer.PrivateGroupOU = "ou=upg,dc=example,dc=com"
+	mockClient := NewMockClient(testConfig)
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	// An entry that appeared in the private group OU is drift too
+	mockClient.Existing["cn=intruder,ou=upg,dc=example,dc=com"] = true
+	if err := mockClient.ApplyPlan(plan); err == nil {
+		t.Fatal("Expected ApplyPlan to refuse a plan when a new private group appeared")
+	}
+	if len(mockClient.Operations) != 0 {
+		t.Errorf("Expected no operations after drift was detected, got %d", len(mockClient.Operations))
+	}
+}
+
+func TestDiffAttributesRemovesDroppedOwnedAttributes(t *testing.T) {
+	current := map[string][]string{
+		"cn":           {"John Doe"},
+		"mail":         {"john@example.com"},
+		"givenName":    {"John"},
+		"userPassword": {"{SSHA}secret"},
+	}
+	desired := map[string][]string{
+		"cn": {"John Doe"},
+	}
+
+	changes := diffAttributes(current, desired, ownedAttributes["person"])
+	removed := make(map[string]bool)
+	for _, change := range changes {
+		if len(change.New) != 0 {
+			t.Errorf("Expected only removals, got %+v", change)
+		}
+		removed[change.Name] = true
+	}
+
+	if !removed["mail"] || !removed["givenName"] {
+		t.Errorf("Expected mail and givenName to be removed, got %+v", changes)
+	}
+	if removed["userPassword"] {
+		t.Error("userPassword is not owned and must not be removed")
+	}
+}
+
+func TestPlanSyncPasswordHash(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	hash := "{SSHA512}bmV3IGhhc2g="
+	testConfig.LDAPEnforcer.Person["sameuser"].PasswordHash = hash
+	mockClient := NewMockClient(testConfig)
+
+	sameDN := mockClient.PersonToDN("sameuser")
+	mockClient.Existing[sameDN] = true
+	mockClient.Entries[sameDN] = mockClient.GetPersonAttributes(&model.Person{Username: "sameuser", CN: "Same User", PasswordHash: hash})
+
+	// moduser has a password set outside of ldapenforcer, which is left alone
+	modDN := mockClient.PersonToDN("moduser")
+	mockClient.Existing[modDN] = true
+	mockClient.Entries[modDN] = mockClient.GetPersonAttributes(&model.Person{Username: "moduser", CN: "Modified User", Mail: "new@example.com", PasswordHash: "{SSHA512}b2xkIGhhc2g="})
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	for _, change := range plan.Changes {
+		if change.DN == sameDN && change.Action != ChangeUnchanged {
+			t.Errorf("Expected sameuser to be unchanged when the hash matches, got %+v", change.Attributes)
+		}
+		if change.DN == modDN && change.Action != ChangeUnchanged {
+			t.Errorf("Expected an unmanaged userPassword to be left alone, got %+v", change.Attributes)
+		}
+	}
+
+	// A different hash only changes userPassword
+	testConfig.LDAPEnforcer.Person["sameuser"].PasswordHash = "{SSHA512}b3RoZXIgaGFzaA=="
+	plan, err = mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	for _, change := range plan.Changes {
+		if change.DN != sameDN {
+			continue
+		}
+		if len(change.Attributes) != 1 || change.Attributes[0].Name != "userPassword" {
+			t.Fatalf("Expected only userPassword to change, got %+v", change.Attributes)
+		}
+		redacted := RedactAttributeChanges(change.Attributes)
+		if redacted[0].New[0] != model.RedactedPassword || redacted[0].Old[0] != model.RedactedPassword {
+			t.Errorf("Expected userPassword values to be redacted, got %+v", redacted[0])
+		}
+	}
+}
+
+func TestPlanSyncRemovesSSHKeys(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	mockClient := NewMockClient(testConfig)
+
+	// sameuser had an SSH key that was removed from the config
+	sameDN := mockClient.PersonToDN("sameuser")
+	mockClient.Existing[sameDN] = true
+	mockClient.Entries[sameDN] = mockClient.GetPersonAttributes(&model.Person{
+		Username: "sameuser",
+		CN:       "Same User",
+		SSHKeys:  []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl old"},
+	})
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	for _, change := range plan.Changes {
+		if change.DN != sameDN {
+			continue
+		}
+		changed := make(map[string]AttributeChange)
+		for _, attr := range change.Attributes {
+			changed[attr.Name] = attr
+		}
+		if attr, ok := changed["sshPublicKey"]; !ok || len(attr.New) != 0 {
+			t.Errorf("Expected sshPublicKey to be removed, got %+v", change.Attributes)
+		}
+		for _, objectClass := range changed["objectClass"].New {
+			if objectClass == "ldapPublicKey" {
+				t.Error("Expected the ldapPublicKey objectClass to be removed")
+			}
+		}
+		if len(changed["objectClass"].New) == 0 {
+			t.Errorf("Expected objectClass to be replaced, got %+v", change.Attributes)
+		}
+		return
+	}
+	t.Error("Expected a change for sameuser")
+}
+
+func TestPlanSyncExtraAttributes(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	testConfig.LDAPEnforcer.Person["sameuser"].Attributes = map[string][]string{"title": {"Engineer"}}
+	testConfig.LDAPEnforcer.Person["sameuser"].ExtraObjectClasses = []string{"customPerson"}
+	// Another person still sets employeeNumber, so it stays owned
+	testConfig.LDAPEnforcer.Person["newuser"].Attributes = map[string][]string{"employeeNumber": {"42"}}
+	mockClient := NewMockClient(testConfig)
+
+	// sameuser has an old title and an employeeNumber that was removed from the config,
+	// plus an attribute no entity manages
+	sameDN := mockClient.PersonToDN("sameuser")
+	mockClient.Existing[sameDN] = true
+	existing := mockClient.GetPersonAttributes(&model.Person{
+		Username:   "sameuser",
+		CN:         "Same User",
+		Attributes: map[string][]string{"title": {"Intern"}, "employeeNumber": {"7"}},
+	})
+	existing["roomNumber"] = []string{"101"}
+	mockClient.Entries[sameDN] = existing
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	for _, change := range plan.Changes {
+		if change.DN != sameDN {
+			continue
+		}
+		changed := make(map[string]AttributeChange)
+		for _, attr := range change.Attributes {
+			changed[attr.Name] = attr
+		}
+		if attr, ok := changed["title"]; !ok || len(attr.New) != 1 || attr.New[0] != "Engineer" {
+			t.Errorf("Expected title to be replaced with Engineer, got %+v", change.Attributes)
+		}
+		if attr, ok := changed["employeeNumber"]; !ok || len(attr.New) != 0 {
+			t.Errorf("Expected employeeNumber to be removed, got %+v", change.Attributes)
+		}
+		if _, ok := changed["roomNumber"]; ok {
+			t.Error("Expected roomNumber to be left alone")
+		}
+		found := false
+		for _, objectClass := range changed["objectClass"].New {
+			if objectClass == "customPerson" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected the customPerson objectClass to be added, got %+v", change.Attributes)
+		}
+		return
+	}
+	t.Error("Expected a change for sameuser")
+}
+
+func TestPlanSyncRemovesLastExtraAttribute(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	newConfig := func() *config.Config {
+		testConfig := newPlanTestConfig()
+		testConfig.LDAPEnforcer.PosixStateFile = statePath
+		return testConfig
+	}
+
+	// sameuser is the only entity that sets title
+	testConfig := newConfig()
+	testConfig.LDAPEnforcer.Person["sameuser"].Attributes = map[string][]string{"title": {"Engineer"}}
+	mockClient := NewMockClient(testConfig)
+	if err := mockClient.SyncAll(); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+	sameDN := mockClient.PersonToDN("sameuser")
+	if title := attributeValues(mockClient.Entries[sameDN], "title"); len(title) != 1 {
+		t.Fatalf("Expected title to be written, got %v", title)
+	}
+
+	// Once no entity sets title, the recorded name keeps it owned, so it is deleted
+	mockClient.config = newConfig()
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	for _, change := range plan.Changes {
+		if change.DN != sameDN {
+			continue
+		}
+		for _, attr := range change.Attributes {
+			if attr.Name == "title" && len(attr.New) == 0 {
+				return
+			}
+		}
+		t.Fatalf("Expected title to be removed, got %+v", change.Attributes)
+	}
+	t.Error("Expected a change for sameuser")
+}
+func TestPlanSyncDisabledAccounts(t *testing.T) {
+	enabled, disabled := false, true
+	testConfig := newPlanTestConfig()
+	testConfig.LDAPEnforcer.Person["sameuser"].Disabled = &disabled
+	testConfig.LDAPEnforcer.Person["moduser"].Disabled = &enabled
+	testConfig.LDAPEnforcer.Person["sameuser"].Posix = []int{1001, 1001}
+	mockClient := NewMockClient(testConfig)
+
+	// sameuser is active in LDAP but disabled in the config
+	sameDN := mockClient.PersonToDN("sameuser")
+	mockClient.Existing[sameDN] = true
+	mockClient.Entries[sameDN] = mockClient.GetPersonAttributes(&model.Person{Username: "sameuser", CN: "Same User", Posix: []int{1001, 1001}})
+
+	// moduser was disabled in LDAP and has been re-enabled in the config
+	modDN := mockClient.PersonToDN("moduser")
+	mockClient.Existing[modDN] = true
+	mockClient.Entries[modDN] = mockClient.GetPersonAttributes(&model.Person{Username: "moduser", CN: "Modified User", Mail: "new@example.com", Disabled: &disabled})
+
+	// newuser was locked by hand, and the config does not mention disabled or expires
+	newDN := mockClient.PersonToDN("newuser")
+	mockClient.Existing[newDN] = true
+	mockClient.Entries[newDN] = mockClient.GetPersonAttributes(&model.Person{Username: "newuser", CN: "New User"})
+	mockClient.Entries[newDN]["nsAccountLock"] = []string{"TRUE"}
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	changes := make(map[string]map[string]AttributeChange)
+	for _, change := range plan.Changes {
+		changes[change.DN] = make(map[string]AttributeChange)
+		for _, attr := range change.Attributes {
+			changes[change.DN][attr.Name] = attr
+		}
+	}
+
+	if attr, ok := changes[sameDN]["nsAccountLock"]; !ok || len(attr.New) != 1 || attr.New[0] != "TRUE" {
+		t.Errorf("Expected sameuser to be locked, got %+v", changes[sameDN])
+	}
+	if attr, ok := changes[sameDN]["shadowExpire"]; !ok || len(attr.New) != 1 || attr.New[0] != "1" {
+		t.Errorf("Expected sameuser to get shadowExpire 1, got %+v", changes[sameDN])
+	}
+	if attr, ok := changes[modDN]["nsAccountLock"]; !ok || len(attr.New) != 1 || attr.New[0] != "FALSE" {
+		t.Errorf("Expected moduser to be unlocked, got %+v", changes[modDN])
+	}
+	if _, ok := changes[newDN]["nsAccountLock"]; ok {
+		t.Errorf("Expected the lock set by hand on newuser to be left alone, got %+v", changes[newDN])
+	}
+}
+
+func TestPlanSyncRemovedDisabledUnlocks(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	newConfig := func() *config.Config {
+		testConfig := newPlanTestConfig()
+		testConfig.LDAPEnforcer.PosixStateFile = statePath
+		return testConfig
+	}
+	lockChange := func(plan *SyncPlan, dn string) *AttributeChange {
+		for _, change := range plan.Changes {
+			for _, attr := range change.Attributes {
+				if change.DN == dn && attr.Name == "nsAccountLock" {
+					return &attr
+				}
+			}
+		}
+		return nil
+	}
+
+	// A sync locks sameuser
+	disabled := true
+	testConfig := newConfig()
+	testConfig.LDAPEnforcer.Person["sameuser"].Disabled = &disabled
+	mockClient := NewMockClient(testConfig)
+	if err := mockClient.SyncAll(); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+	sameDN := mockClient.PersonToDN("sameuser")
+
+	// Removing disabled unlocks the account the sync locked
+	mockClient.config = newConfig()
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if attr := lockChange(plan, sameDN); attr == nil || len(attr.New) != 1 || attr.New[0] != "FALSE" {
+		t.Fatalf("Expected sameuser to be unlocked, got %+v", attr)
+	}
+	if err := mockClient.ApplyPlan(plan); err != nil {
+		t.Fatalf("ApplyPlan failed: %v", err)
+	}
+
+	// Once it is unlocked, a lock set by hand is left alone again
+	mockClient.config = newConfig()
+	if err := mockClient.SyncAll(); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+	mockClient.Entries[sameDN]["nsAccountLock"] = []string{"TRUE"}
+	mockClient.config = newConfig()
+	plan, err = mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if attr := lockChange(plan, sameDN); attr != nil {
+		t.Errorf("Expected the lock set by hand to be left alone, got %+v", attr)
+	}
+}
+
+func TestDiffAttributesAccountLockIgnoresCase(t *testing.T) {
+	current := map[string][]string{"nsAccountLock": {"true"}}
+	desired := map[string][]string{"nsAccountLock": {"TRUE"}}
+
+	if changes := diffAttributes(current, desired, nil); len(changes) != 0 {
+		t.Errorf("Expected no changes for a differently cased nsAccountLock, got %+v", changes)
+	}
+}
+
+func TestPlanSyncGroupNestingReference(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	testConfig.LDAPEnforcer.GroupNesting = model.NestingReference
+	testConfig.LDAPEnforcer.Group["parent"] = &model.Group{
+		Description: "Parent Group",
+		People:      []string{"moduser"},
+		Groups:      []string{"testgroup"},
+	}
+	// A group can still flatten its nested groups when the global mode is reference
+	testConfig.LDAPEnforcer.Group["flat"] = &model.Group{
+		Description: "Flat Group",
+		Groups:      []string{"testgroup"},
+		Nesting:     model.NestingFlatten,
+	}
+	mockClient := NewMockClient(testConfig)
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	childDN := mockClient.GroupToDN("testgroup")
+	position := make(map[string]int)
+	members := make(map[string][]string)
+	for i, change := range plan.Changes {
+		position[change.ID] = i
+		for _, attr := range change.Attributes {
+			if attr.Name == "member" {
+				members[change.ID] = attr.New
+			}
+		}
+	}
+
+	if position["testgroup"] > position["parent"] {
+		t.Error("Expected the referenced group to be created before the group that references it")
+	}
+	if !sameValues(members["parent"], []string{mockClient.PersonToDN("moduser"), childDN}) {
+		t.Errorf("Expected parent to reference testgroup, got members %v", members["parent"])
+	}
+	if !sameValues(members["flat"], []string{mockClient.PersonToDN("newuser"), mockClient.PersonToDN("sameuser")}) {
+		t.Errorf("Expected flat to list the members of testgroup, got members %v", members["flat"])
+	}
+}
+
+func TestPlanSyncExternalMembers(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	legacyDN := "uid=backup,ou=legacy,dc=example,dc=com"
+	testConfig.LDAPEnforcer.Group["testgroup"].ExternalMembers = []string{legacyDN}
+	// A group of only external members is not empty
+	testConfig.LDAPEnforcer.Group["legacy"] = &model.Group{
+		Description:     "Legacy Accounts",
+		ExternalMembers: []string{legacyDN},
+	}
+	mockClient := NewMockClient(testConfig)
+	mockClient.Existing[legacyDN] = true
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	members := make(map[string][]string)
+	for _, change := range plan.Changes {
+		if change.DN == legacyDN {
+			t.Errorf("Expected no change to the external member, got %s", change.Action)
+		}
+		for _, attr := range change.Attributes {
+			if attr.Name == "member" {
+				members[change.ID] = attr.New
+			}
+		}
+	}
+
+	expected := []string{mockClient.PersonToDN("newuser"), mockClient.PersonToDN("sameuser"), legacyDN}
+	if !sameValues(members["testgroup"], expected) {
+		t.Errorf("Expected testgroup members %v, got %v", expected, members["testgroup"])
+	}
+	if !sameValues(members["legacy"], []string{legacyDN}) {
+		t.Errorf("Expected legacy members [%s], got %v", legacyDN, members["legacy"])
+	}
+}
+
+func TestPlanSyncUserPrivateGroups(t *testing.T) {
+	noPrivateGroup := false
+	testConfig := newPlanTestConfig()
+	testConfig.LDAPEnforcer.UserPrivateGroup = true
+	testConfig.LDAPEnforcer.PrivateGroupOU = "ou=private,ou=groups,dc=example,dc=com"
+	testConfig.LDAPEnforcer.Person["alice"] = &model.Person{CN: "Alice", Posix: []int{1001, 1001}}
+	testConfig.LDAPEnforcer.Person["bob"] = &model.Person{CN: "Bob", Posix: []int{1002, 1002}, UserPrivateGroup: &noPrivateGroup}
+	mockClient := NewMockClient(testConfig)
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	aliceGroupDN := "cn=alice,ou=private,ou=groups,dc=example,dc=com"
+	var created []string
+	for _, change := range plan.Changes {
+		if change.EntityType != "group" || change.Action != ChangeCreate {
+			continue
+		}
+		created = append(created, change.DN)
+		if change.DN != aliceGroupDN {
+			continue
+		}
+		attrs := make(map[string][]string)
+		for _, attr := range change.Attributes {
+			attrs[attr.Name] = attr.New
+		}
+		if !sameValues(attrs["gidNumber"], []string{"1001"}) {
+			t.Errorf("Expected the private group to have alice's GID number, got %v", attrs["gidNumber"])
+		}
+		if !sameValues(attrs["member"], []string{mockClient.PersonToDN("alice")}) {
+			t.Errorf("Expected alice to be the only member, got %v", attrs["member"])
+		}
+	}
+	// Only POSIX people that have not opted out get a private group
+	expected := []string{mockClient.GroupToDN("testgroup"), aliceGroupDN}
+	if !sameValues(created, expected) {
+		t.Fatalf("Expected groups %v to be created, got %v", expected, created)
+	}
+
+	// The private group is deleted along with the person
+	if err := mockClient.ApplyPlan(plan); err != nil {
+		t.Fatalf("ApplyPlan failed: %v", err)
+	}
+	delete(testConfig.LDAPEnforcer.Person, "alice")
+	plan, err = mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	deleted := make(map[string]bool)
+	for _, change := range plan.Changes {
+		if change.Action == ChangeDelete {
+			deleted[change.DN] = true
+		}
+	}
+	if !deleted[aliceGroupDN] || !deleted[mockClient.PersonToDN("alice")] {
+		t.Errorf("Expected alice and her private group to be deleted, got %v", deleted)
+	}
+}
+
+func TestPlanSyncPrimaryGroup(t *testing.T) {
+	member, notMember := true, false
+
+	tests := []struct {
+		name          string
+		global        bool
+		override      *bool
+		expectMembers []string
+	}{
+		{"not a member by default", false, nil, []string{"sameuser"}},
+		{"member with the global setting", true, nil, []string{"sameuser", "alice"}},
+		{"member with the account setting", false, &member, []string{"sameuser", "alice"}},
+		{"account setting overrides the global setting", true, &notMember, []string{"sameuser"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig := newPlanTestConfig()
+			testConfig.LDAPEnforcer.GIDRange = []int{30000, 30009}
+			testConfig.LDAPEnforcer.PrimaryGroupMember = tt.global
+			testConfig.LDAPEnforcer.Group["employees"] = &model.Group{Description: "Employees", People: []string{"sameuser"}, AutoPosix: true}
+			testConfig.LDAPEnforcer.Person["alice"] = &model.Person{CN: "Alice", Posix: []int{1001}, PrimaryGroup: "employees", PrimaryGroupMember: tt.override}
+			mockClient := NewMockClient(testConfig)
+
+			plan, err := mockClient.PlanSync()
+			if err != nil {
+				t.Fatalf("PlanSync failed: %v", err)
+			}
+
+			created := make(map[string]map[string][]string)
+			for _, change := range plan.Changes {
+				if change.Action != ChangeCreate {
+					continue
+				}
+				attrs := make(map[string][]string)
+				for _, attr := range change.Attributes {
+					attrs[attr.Name] = attr.New
+				}
+				created[change.DN] = attrs
+			}
+			// The GID number comes from the group, once it has been allocated
+			alice := created[mockClient.PersonToDN("alice")]
+			if !sameValues(alice["gidNumber"], []string{"30000"}) {
+				t.Errorf("Expected alice to have the GID number of employees, got %v", alice["gidNumber"])
+			}
+			// The person is only a member of their primary group with primary_group_member
+			var expected []string
+			for _, uid := range tt.expectMembers {
+				expected = append(expected, mockClient.PersonToDN(uid))
+			}
+			employees := created[mockClient.GroupToDN("employees")]
+			if !sameValues(employees["member"], expected) {
+				t.Errorf("Expected members %v of employees, got %v", expected, employees["member"])
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+package ldap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mrled/ldapenforcer/internal/config"
+	"github.com/mrled/ldapenforcer/internal/logging"
+	"github.com/mrled/ldapenforcer/internal/model"
+)
+
+// posixState records the numbers allocated to entities with auto_posix, by name.
+// Entries are kept after an entity is removed, so that its numbers are never reused.
+// It also records, by entity type, the attribute names from attributes tables that syncs have written,
+// so that the enforcer keeps owning an attribute after the last entity stops setting it,
+// and the accounts whose nsAccountLock syncs have set, so that they are unlocked once they stop setting it.
+type posixState struct {
+	People     map[string]int      `json:"people,omitempty"`
+	SvcAccts   map[string]int      `json:"svcaccts,omitempty"`
+	Groups     map[string]int      `json:"groups,omitempty"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+	Locks      map[string][]string `json:"locks,omitempty"`
+}
+
+// loadPosixState reads a state file, returning an empty state if it does not exist yet
+func loadPosixState(path string) (*posixState, error) {
+	state := &posixState{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read POSIX state file: %w", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, state); err != nil {
+				return nil, fmt.Errorf("failed to parse POSIX state file %s: %w", path, err)
+			}
+		}
+	}
+	if state.People == nil {
+		state.People = make(map[string]int)
+	}
+	if state.SvcAccts == nil {
+		state.SvcAccts = make(map[string]int)
+	}
+	if state.Groups == nil {
+		state.Groups = make(map[string]int)
+	}
+	if state.Attributes == nil {
+		state.Attributes = make(map[string][]string)
+	}
+	if state.Locks == nil {
+		state.Locks = make(map[string][]string)
+	}
+	return state, nil
+}
+
+// save writes the state file, replacing it atomically so that a failed write never loses allocations
+func (s *posixState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode POSIX state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".posix-state-*")
+	if err != nil {
+		return fmt.Errorf("failed to write POSIX state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write POSIX state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write POSIX state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write POSIX state file: %w", err)
+	}
+	return nil
+}
+
+// empty returns true if the state records no numbers
+func (s *posixState) empty() bool {
+	return len(s.People) == 0 && len(s.SvcAccts) == 0 && len(s.Groups) == 0
+}
+
+// recordPosixAllocations adds newly allocated numbers to the state file.
+// Only syncs and applied plans record numbers, so that previews never use numbers up.
+// Numbers already recorded in the file are kept.
+func recordPosixAllocations(cfg *config.Config, allocated *posixState) error {
+	statePath := cfg.GetPosixStateFile()
+	if statePath == "" || allocated == nil || allocated.empty() {
+		return nil
+	}
+	state, err := loadPosixState(statePath)
+	if err != nil {
+		return err
+	}
+	for _, maps := range [][2]map[string]int{
+		{state.People, allocated.People},
+		{state.SvcAccts, allocated.SvcAccts},
+		{state.Groups, allocated.Groups},
+	} {
+		for name, number := range maps[1] {
+			if _, ok := maps[0][name]; !ok {
+				maps[0][name] = number
+			}
+		}
+	}
+	return state.save(statePath)
+}
+
+// recordWrittenState records in the state file what a sync or an applied plan is about to write:
+// the attribute names from the attributes tables in the configuration, and the accounts whose nsAccountLock it sets.
+// Recorded attribute names are kept, so that their values are deleted once no entity sets them.
+// An account that stopped setting disabled or expires stays recorded while its entry is still locked,
+// so that this sync unlocks it; after that, its lock is left alone again.
+func recordWrittenState(c LDAPClientInterface, cfg *config.Config) error {
+	statePath := cfg.GetPosixStateFile()
+	if statePath == "" {
+		return nil
+	}
+	state, err := loadPosixState(statePath)
+	if err != nil {
+		return err
+	}
+	changed := false
+
+	for _, entityType := range []string{"person", "svcacct", "group"} {
+		seen := make(map[string]bool)
+		for _, name := range state.Attributes[entityType] {
+			seen[strings.ToLower(name)] = true
+		}
+		for _, name := range configuredAttributes(cfg, entityType) {
+			if !seen[strings.ToLower(name)] {
+				seen[strings.ToLower(name)] = true
+				state.Attributes[entityType] = append(state.Attributes[entityType], name)
+				changed = true
+			}
+		}
+		sort.Strings(state.Attributes[entityType])
+	}
+
+	locks := map[string]map[string]bool{"person": {}, "svcacct": {}}
+	for uid, person := range cfg.LDAPEnforcer.Person {
+		if person.ManagesLock() {
+			locks["person"][uid] = true
+		}
+	}
+	for uid, svcacct := range cfg.LDAPEnforcer.SvcAcct {
+		if svcacct.ManagesLock() {
+			locks["svcacct"][uid] = true
+		}
+	}
+	for _, entityType := range []string{"person", "svcacct"} {
+		for _, uid := range state.Locks[entityType] {
+			if locks[entityType][uid] {
+				continue
+			}
+			dn := c.PersonToDN(uid)
+			_, configured := cfg.LDAPEnforcer.Person[uid]
+			if entityType == "svcacct" {
+				dn = c.SvcAcctToDN(uid)
+				_, configured = cfg.LDAPEnforcer.SvcAcct[uid]
+			}
+			if !configured {
+				// Removed accounts are deleted or quarantined instead
+				continue
+			}
+			current, err := c.GetEntryAttributes(dn)
+			if err != nil {
+				return fmt.Errorf("failed to check whether %s is locked: %w", dn, err)
+			}
+			if lock := attributeValues(current, "nsAccountLock"); len(lock) == 1 && strings.EqualFold(lock[0], "TRUE") {
+				locks[entityType][uid] = true
+			}
+		}
+		uids := sortedKeys(locks[entityType])
+		if !slices.Equal(uids, state.Locks[entityType]) {
+			changed = true
+			if len(uids) > 0 {
+				state.Locks[entityType] = uids
+			} else {
+				delete(state.Locks, entityType)
+			}
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	return state.save(statePath)
+}
+
+// posixRequest is an entity that needs an allocated number
+type posixRequest struct {
+	entityType string
+	name       string
+	dn         string
+	recorded   map[string]int
+	allocated  map[string]int
+	number     int
+}
+
+// allocatePosixIDs sets the POSIX numbers of people, service accounts, and groups with auto_posix
+// that do not set them explicitly. Each entity keeps the number recorded in the state file,
+// or else the number on its existing entry in LDAP; the rest get the lowest free number in uid_range or gid_range.
+// People and service accounts get the template's gidNumber as their GID number, or else their UID number.
+// A number is free if no configured entity, state file entry, or entry in the enforced OUs uses it;
+// new UID numbers must be free as GID numbers too, so that an account's GID number never belongs to a group.
+// The state file is not written; the numbers that are not recorded in it yet are returned instead.
+func allocatePosixIDs(c LDAPClientInterface, cfg *config.Config) (*posixState, error) {
+	state, err := loadPosixState(cfg.GetPosixStateFile())
+	if err != nil {
+		return nil, err
+	}
+	allocated := &posixState{
+		People:   make(map[string]int),
+		SvcAccts: make(map[string]int),
+		Groups:   make(map[string]int),
+	}
+	templates, err := model.ResolveTemplates(cfg.LDAPEnforcer.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	// Explicit numbers and every recorded number are in use;
+	// accounts with a primary group may list only their UID number until it is resolved
+	usedUIDs := make(map[int]bool)
+	usedGIDs := make(map[int]bool)
+	var accounts, groups []*posixRequest
+	for _, uid := range sortedKeys(cfg.LDAPEnforcer.Person) {
+		person := cfg.LDAPEnforcer.Person[uid]
+		if len(person.Posix) > 0 {
+			usedUIDs[person.Posix[0]] = true
+			if len(person.Posix) > 1 {
+				usedGIDs[person.Posix[1]] = true
+			}
+		} else if person.AutoPosix {
+			accounts = append(accounts, &posixRequest{entityType: "person", name: uid, dn: c.PersonToDN(uid), recorded: state.People, allocated: allocated.People})
+		}
+	}
+	for _, uid := range sortedKeys(cfg.LDAPEnforcer.SvcAcct) {
+		svcacct := cfg.LDAPEnforcer.SvcAcct[uid]
+		if len(svcacct.Posix) > 0 {
+			usedUIDs[svcacct.Posix[0]] = true
+			if len(svcacct.Posix) > 1 {
+				usedGIDs[svcacct.Posix[1]] = true
+			}
+		} else if svcacct.AutoPosix {
+			accounts = append(accounts, &posixRequest{entityType: "svcacct", name: uid, dn: c.SvcAcctToDN(uid), recorded: state.SvcAccts, allocated: allocated.SvcAccts})
+		}
+	}
+	for _, groupname := range sortedKeys(cfg.LDAPEnforcer.Group) {
+		group := cfg.LDAPEnforcer.Group[groupname]
+		if group.IsPosix() {
+			usedGIDs[group.PosixGidNumber] = true
+		} else if group.AutoPosix {
+			groups = append(groups, &posixRequest{entityType: "group", name: groupname, dn: c.GroupToDN(groupname), recorded: state.Groups, allocated: allocated.Groups})
+		}
+	}
+	// Recorded accounts may use their UID number as their GID number
+	for _, recorded := range []map[string]int{state.People, state.SvcAccts} {
+		for _, number := range recorded {
+			usedUIDs[number] = true
+			usedGIDs[number] = true
+		}
+	}
+	for _, number := range state.Groups {
+		usedGIDs[number] = true
+	}
+
+	// Numbers on entries in the enforced OUs are in use too, including entries that are not in the config (yet)
+	existing := []struct {
+		ou        string
+		entryType string
+		attrName  string
+		used      map[int]bool
+	}{
+		{cfg.LDAPEnforcer.EnforcedPeopleOU, "person", "uidNumber", usedUIDs},
+		{cfg.LDAPEnforcer.EnforcedPeopleOU, "person", "gidNumber", usedGIDs},
+		{cfg.LDAPEnforcer.EnforcedSvcAcctOU, "svcacct", "uidNumber", usedUIDs},
+		{cfg.LDAPEnforcer.EnforcedSvcAcctOU, "svcacct", "gidNumber", usedGIDs},
+		{cfg.LDAPEnforcer.QuarantineOU, "person", "uidNumber", usedUIDs},
+		{cfg.LDAPEnforcer.QuarantineOU, "person", "gidNumber", usedGIDs},
+		{cfg.LDAPEnforcer.EnforcedGroupOU, "group", "gidNumber", usedGIDs},
+		{cfg.LDAPEnforcer.PrivateGroupOU, "group", "gidNumber", usedGIDs},
+	}
+	for _, ou := range existing {
+		if ou.ou == "" {
+			continue
+		}
+		if err := markExistingNumbers(c, ou.ou, ou.entryType, ou.attrName, ou.used); err != nil {
+			return nil, err
+		}
+	}
+
+	// A new UID number usually doubles as the account's GID number, so it must be free as both
+	usedIDs := make(map[int]bool, len(usedUIDs)+len(usedGIDs))
+	for _, used := range []map[int]bool{usedUIDs, usedGIDs} {
+		for number := range used {
+			usedIDs[number] = true
+		}
+	}
+	if err := allocateNumbers(c, accounts, "uidNumber", cfg.LDAPEnforcer.UIDRange, usedIDs); err != nil {
+		return nil, err
+	}
+	for _, request := range accounts {
+		switch request.entityType {
+		case "person":
+			person := cfg.LDAPEnforcer.Person[request.name]
+			person.Posix = []int{request.number, accountGIDNumber(templates, person.Template, request.number)}
+		case "svcacct":
+			svcacct := cfg.LDAPEnforcer.SvcAcct[request.name]
+			svcacct.Posix = []int{request.number, accountGIDNumber(templates, svcacct.Template, request.number)}
+		}
+	}
+
+	// The GID numbers of accounts, which user private groups also use, are not free for groups
+	for _, person := range cfg.LDAPEnforcer.Person {
+		if len(person.Posix) > 1 {
+			usedGIDs[person.Posix[1]] = true
+		}
+	}
+	for _, svcacct := range cfg.LDAPEnforcer.SvcAcct {
+		if len(svcacct.Posix) > 1 {
+			usedGIDs[svcacct.Posix[1]] = true
+		}
+	}
+
+	if err := allocateNumbers(c, groups, "gidNumber", cfg.LDAPEnforcer.GIDRange, usedGIDs); err != nil {
+		return nil, err
+	}
+	for _, request := range groups {
+		cfg.LDAPEnforcer.Group[request.name].PosixGidNumber = request.number
+	}
+
+	return allocated, nil
+}
+
+// allocateNumbers gives each request its recorded number, or else the number on its existing entry,
+// or else the lowest free number in idRange. Numbers that are not recorded yet are added to the request's allocated map.
+func allocateNumbers(c LDAPClientInterface, requests []*posixRequest, attrName string, idRange []int, used map[int]bool) error {
+	// Reuse existing numbers before allocating new ones, so that a new entity cannot take an existing entity's number
+	for _, request := range requests {
+		if number, ok := request.recorded[request.name]; ok {
+			request.number = number
+			continue
+		}
+		attrs, err := c.GetEntryAttributes(request.dn)
+		if err != nil {
+			return err
+		}
+		if values := attributeValues(attrs, attrName); len(values) == 1 {
+			if number, err := strconv.Atoi(values[0]); err == nil && number > 0 {
+				request.number = number
+				used[number] = true
+			}
+		}
+	}
+	for _, request := range requests {
+		if request.number == 0 {
+			number, err := nextFreeNumber(idRange, used)
+			if err != nil {
+				return fmt.Errorf("failed to allocate %s for %s %s: %w", attrName, request.entityType, request.name, err)
+			}
+			request.number = number
+			logging.DefaultLogger.Info("Allocated %s %d to %s %s", attrName, number, request.entityType, request.name)
+		}
+		if request.recorded[request.name] != request.number {
+			request.allocated[request.name] = request.number
+		}
+	}
+	return nil
+}
+
+// markExistingNumbers marks the numbers of an attribute on every entry in an OU as used
+func markExistingNumbers(c LDAPClientInterface, ou, entryType, attrName string, used map[int]bool) error {
+	entries, err := c.GetExistingEntries(ou, entryType)
+	if err != nil {
+		return fmt.Errorf("failed to get existing entries in %s: %w", ou, err)
+	}
+	for _, dn := range sortedKeys(entries) {
+		attrs, err := c.GetEntryAttributes(dn)
+		if err != nil {
+			return err
+		}
+		for _, value := range attributeValues(attrs, attrName) {
+			if number, err := strconv.Atoi(value); err == nil {
+				used[number] = true
+			}
+		}
+	}
+	return nil
+}
+
+// accountGIDNumber returns the GID number of an account with an allocated UID number:
+// its template's gidNumber, or else the UID number itself
+func accountGIDNumber(templates map[string]*model.Template, templateName string, uidNumber int) int {
+	if template, ok := templates[templateName]; ok && template.GidNumber != 0 {
+		return template.GidNumber
+	}
+	return uidNumber
+}
+
+// nextFreeNumber returns the lowest number in idRange that is not used, and marks it as used
+func nextFreeNumber(idRange []int, used map[int]bool) (int, error) {
+	if len(idRange) != 2 {
+		return 0, fmt.Errorf("no range configured")
+	}
+	for number := idRange[0]; number <= idRange[1]; number++ {
+		if !used[number] {
+			used[number] = true
+			return number, nil
+		}
+	}
+	return 0, fmt.Errorf("no free number left in range %d-%d", idRange[0], idRange[1])
+}
@@ -0,0 +1,234 @@
+package ldap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mrled/ldapenforcer/internal/config"
+	"github.com/mrled/ldapenforcer/internal/model"
+)
+
+func newPosixAllocTestConfig(statePath string) *config.Config {
+	return &config.Config{
+		LDAPEnforcer: config.LDAPEnforcerConfig{
+			EnforcedPeopleOU:  "ou=people,dc=example,dc=com",
+			EnforcedSvcAcctOU: "ou=svcaccts,dc=example,dc=com",
+			EnforcedGroupOU:   "ou=groups,dc=example,dc=com",
+			UIDRange:          []int{20000, 20009},
+			GIDRange:          []int{30000, 30009},
+			PosixStateFile:    statePath,
+			Template: map[string]*model.Template{
+				"service": {GidNumber: 500},
+			},
+			Person: map[string]*model.Person{
+				"alice": {CN: "Alice", AutoPosix: true},
+				"bob":   {CN: "Bob", Posix: []int{20000, 100}},
+				"carol": {CN: "Carol", AutoPosix: true},
+			},
+			SvcAcct: map[string]*model.SvcAcct{
+				"backup": {CN: "Backup", Description: "Backup service", AutoPosix: true, Template: "service"},
+			},
+			Group: map[string]*model.Group{
+				"staff": {Description: "Staff", People: []string{"alice"}, AutoPosix: true},
+			},
+		},
+	}
+}
+
+func TestAllocatePosixIDs(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "posix-state.json")
+	testConfig := newPosixAllocTestConfig(statePath)
+	mockClient := NewMockClient(testConfig)
+
+	// carol already exists with a number, which is kept
+	carolDN := mockClient.PersonToDN("carol")
+	mockClient.Existing[carolDN] = true
+	mockClient.Entries[carolDN] = map[string][]string{"uidNumber": {"20001"}}
+
+	if err := mockClient.SyncAll(); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+
+	people := testConfig.LDAPEnforcer.Person
+	// bob's explicit UID number is never allocated, so alice gets the lowest free number
+	if !reflect.DeepEqual(people["alice"].Posix, []int{20002, 20002}) {
+		t.Errorf("Expected alice to get [20002 20002], got %v", people["alice"].Posix)
+	}
+	if !reflect.DeepEqual(people["carol"].Posix, []int{20001, 20001}) {
+		t.Errorf("Expected carol to keep her existing number, got %v", people["carol"].Posix)
+	}
+	if !reflect.DeepEqual(people["bob"].Posix, []int{20000, 100}) {
+		t.Errorf("Expected bob's explicit numbers to be kept, got %v", people["bob"].Posix)
+	}
+	// The template supplies the GID number
+	if posix := testConfig.LDAPEnforcer.SvcAcct["backup"].Posix; !reflect.DeepEqual(posix, []int{20003, 500}) {
+		t.Errorf("Expected backup to get [20003 500], got %v", posix)
+	}
+	if gid := testConfig.LDAPEnforcer.Group["staff"].PosixGidNumber; gid != 30000 {
+		t.Errorf("Expected staff to get GID number 30000, got %d", gid)
+	}
+
+	// A fresh configuration gets the same numbers from the state file, even without LDAP entries,
+	// and numbers of removed entities are not reused
+	testConfig = newPosixAllocTestConfig(statePath)
+	delete(testConfig.LDAPEnforcer.Person, "carol")
+	testConfig.LDAPEnforcer.Person["dave"] = &model.Person{CN: "Dave", AutoPosix: true}
+	if err := NewMockClient(testConfig).SyncAll(); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+	people = testConfig.LDAPEnforcer.Person
+	if !reflect.DeepEqual(people["alice"].Posix, []int{20002, 20002}) {
+		t.Errorf("Expected alice to keep [20002 20002], got %v", people["alice"].Posix)
+	}
+	if !reflect.DeepEqual(people["dave"].Posix, []int{20004, 20004}) {
+		t.Errorf("Expected dave to get [20004 20004], got %v", people["dave"].Posix)
+	}
+
+	state, err := loadPosixState(statePath)
+	if err != nil {
+		t.Fatalf("loadPosixState failed: %v", err)
+	}
+	expected := map[string]int{"alice": 20002, "carol": 20001, "dave": 20004}
+	if !reflect.DeepEqual(state.People, expected) {
+		t.Errorf("Expected recorded people %v, got %v", expected, state.People)
+	}
+}
+
+func TestAllocatePosixIDsWithoutStateFile(t *testing.T) {
+	testConfig := newPosixAllocTestConfig("")
+	mockClient := NewMockClient(testConfig)
+
+	// Without a state file, numbers are read back from the entries a sync created
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if err := mockClient.ApplyPlan(plan); err != nil {
+		t.Fatalf("ApplyPlan failed: %v", err)
+	}
+	if !reflect.DeepEqual(mockClient.Entries[mockClient.PersonToDN("alice")]["uidNumber"], []string{"20001"}) {
+		t.Fatalf("Expected alice to be created with uidNumber 20001, got %v", mockClient.Entries[mockClient.PersonToDN("alice")])
+	}
+
+	// carol sorts after alice, but keeps her number when alice is not being allocated one
+	testConfig = newPosixAllocTestConfig("")
+	delete(testConfig.LDAPEnforcer.Person, "alice")
+	mockClient.config = testConfig
+	if err := mockClient.PrepareConfig(); err != nil {
+		t.Fatalf("PrepareConfig failed: %v", err)
+	}
+	if posix := testConfig.LDAPEnforcer.Person["carol"].Posix; !reflect.DeepEqual(posix, []int{20002, 20002}) {
+		t.Errorf("Expected carol to keep [20002 20002], got %v", posix)
+	}
+}
+
+func TestAllocatePosixIDsOnlyRecordedWhenApplied(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "posix-state.json")
+	mockClient := NewMockClient(newPosixAllocTestConfig(statePath))
+
+	// Previews allocate numbers without recording them
+	if err := NewMockClient(newPosixAllocTestConfig(statePath)).PrepareConfig(); err != nil {
+		t.Fatalf("PrepareConfig failed: %v", err)
+	}
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if _, err := os.Stat(statePath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected no state file after previews, got %v", err)
+	}
+
+	// Applying the plan records the numbers it allocated
+	if err := mockClient.ApplyPlan(plan); err != nil {
+		t.Fatalf("ApplyPlan failed: %v", err)
+	}
+	state, err := loadPosixState(statePath)
+	if err != nil {
+		t.Fatalf("loadPosixState failed: %v", err)
+	}
+	expected := map[string]int{"alice": 20001, "carol": 20002}
+	if !reflect.DeepEqual(state.People, expected) {
+		t.Errorf("Expected recorded people %v, got %v", expected, state.People)
+	}
+	if !reflect.DeepEqual(state.Groups, map[string]int{"staff": 30000}) {
+		t.Errorf("Expected staff to be recorded with 30000, got %v", state.Groups)
+	}
+}
+
+func TestAllocatePosixIDsAvoidsNumbersInUse(t *testing.T) {
+	testConfig := newPosixAllocTestConfig("")
+	// bob's GID number is in the GID range
+	testConfig.LDAPEnforcer.Person["bob"].Posix = []int{20000, 30000}
+	mockClient := NewMockClient(testConfig)
+
+	// An entry that is not in the config uses a UID number, and a group uses a GID number
+	strayDN := mockClient.PersonToDN("stray")
+	mockClient.Existing[strayDN] = true
+	mockClient.Entries[strayDN] = map[string][]string{"uidNumber": {"20001"}}
+	otherDN := mockClient.GroupToDN("other")
+	mockClient.Existing[otherDN] = true
+	mockClient.Entries[otherDN] = map[string][]string{"gidNumber": {"30001"}}
+
+	if err := mockClient.PrepareConfig(); err != nil {
+		t.Fatalf("PrepareConfig failed: %v", err)
+	}
+
+	if posix := testConfig.LDAPEnforcer.Person["alice"].Posix; !reflect.DeepEqual(posix, []int{20002, 20002}) {
+		t.Errorf("Expected alice to get [20002 20002], got %v", posix)
+	}
+	// alice's and carol's GID numbers are outside the GID range; bob's and the existing group's are skipped
+	if gid := testConfig.LDAPEnforcer.Group["staff"].PosixGidNumber; gid != 30002 {
+		t.Errorf("Expected staff to get GID number 30002, got %d", gid)
+	}
+}
+
+func TestAllocatePosixIDsOverlappingRanges(t *testing.T) {
+	newConfig := func() *config.Config {
+		testConfig := newPosixAllocTestConfig("")
+		testConfig.LDAPEnforcer.GIDRange = testConfig.LDAPEnforcer.UIDRange
+		delete(testConfig.LDAPEnforcer.Person, "bob")
+		delete(testConfig.LDAPEnforcer.Person, "carol")
+		delete(testConfig.LDAPEnforcer.SvcAcct, "backup")
+		return testConfig
+	}
+
+	// The first sync gives alice 20000, and staff the next GID number, 20001
+	testConfig := newConfig()
+	mockClient := NewMockClient(testConfig)
+	if err := mockClient.SyncAll(); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+	staffGID := testConfig.LDAPEnforcer.Group["staff"].PosixGidNumber
+	if staffGID != 20001 {
+		t.Fatalf("Expected staff to get GID number 20001, got %d", staffGID)
+	}
+
+	// A new account must not get a UID number that is a group's GID number,
+	// because it doubles as the account's GID number
+	testConfig = newConfig()
+	testConfig.LDAPEnforcer.Person["dave"] = &model.Person{CN: "Dave", AutoPosix: true}
+	mockClient.config = testConfig
+	if err := mockClient.PrepareConfig(); err != nil {
+		t.Fatalf("PrepareConfig failed: %v", err)
+	}
+	if posix := testConfig.LDAPEnforcer.Person["dave"].Posix; !reflect.DeepEqual(posix, []int{20002, 20002}) {
+		t.Errorf("Expected dave to get [20002 20002], got %v", posix)
+	}
+	if gid := testConfig.LDAPEnforcer.Group["staff"].PosixGidNumber; gid != staffGID {
+		t.Errorf("Expected staff to keep GID number %d, got %d", staffGID, gid)
+	}
+}
+
+func TestAllocatePosixIDsRangeExhausted(t *testing.T) {
+	testConfig := newPosixAllocTestConfig("")
+	testConfig.LDAPEnforcer.UIDRange = []int{20000, 20001}
+
+	err := NewMockClient(testConfig).PrepareConfig()
+	if err == nil || !strings.Contains(err.Error(), "no free number") {
+		t.Errorf("Expected the range to be exhausted, got %v", err)
+	}
+}
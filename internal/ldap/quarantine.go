@@ -0,0 +1,228 @@
+package ldap
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/mrled/ldapenforcer/internal/config"
+	"github.com/mrled/ldapenforcer/internal/logging"
+)
+
+// quarantineStampPrefix starts the description value that records when an entry was quarantined.
+// The full value is "<prefix> <entity type> <RFC 3339 timestamp>".
+const quarantineStampPrefix = "ldapenforcer-quarantined"
+
+// QuarantineToDN converts a uid to its DN in the quarantine OU,
+// or returns an empty string if no quarantine OU is configured
+func (b *BaseClient) QuarantineToDN(uid string) string {
+	if b.config.LDAPEnforcer.QuarantineOU == "" {
+		return ""
+	}
+	return fmt.Sprintf("uid=%s,%s",
+		ldap.EscapeFilter(uid),
+		b.config.LDAPEnforcer.QuarantineOU)
+}
+
+// quarantineStamp returns the description value that marks an entry as quarantined at a given time
+func quarantineStamp(entityType string, at time.Time) string {
+	return fmt.Sprintf("%s %s %s", quarantineStampPrefix, entityType, at.UTC().Format(time.RFC3339))
+}
+
+// isQuarantineStamp reports whether a description value is a quarantine stamp
+func isQuarantineStamp(value string) bool {
+	return strings.HasPrefix(value, quarantineStampPrefix+" ")
+}
+
+// parseQuarantineStamp finds the quarantine stamp in an entry's description values.
+// ok is false for entries that were not quarantined by ldapenforcer.
+func parseQuarantineStamp(attrs map[string][]string) (entityType string, at time.Time, ok bool) {
+	for _, value := range attributeValues(attrs, "description") {
+		if !isQuarantineStamp(value) {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) != 3 {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			continue
+		}
+		return fields[1], at, true
+	}
+	return "", time.Time{}, false
+}
+
+// attributeValues returns the values of an attribute, matching its name case-insensitively
+func attributeValues(attrs map[string][]string, name string) []string {
+	for attr, values := range attrs {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// removalChange returns the change for a person, service account, or group that is no longer in the configuration.
+// People and service accounts are quarantined when a quarantine OU is configured; everything else is deleted.
+func removalChange(c LDAPClientInterface, entityType, dn string) (*EntityChange, error) {
+	if entityType == "group" {
+		return deleteChange(c, entityType, dn)
+	}
+	uid := getRDNValue(dn)
+	quarantineDN := c.QuarantineToDN(uid)
+	if quarantineDN == "" {
+		return deleteChange(c, entityType, dn)
+	}
+
+	current, err := c.GetEntryAttributes(dn)
+	if err != nil {
+		return nil, err
+	}
+
+	// ModDN fails if the target already exists, e.g. a person and a service account with the same uid
+	exists, err := c.EntryExists(quarantineDN)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("cannot quarantine %s: %s already exists", dn, quarantineDN)
+	}
+
+	description := attributeValues(current, "description")
+	return &EntityChange{
+		Action:      ChangeQuarantine,
+		EntityType:  entityType,
+		ID:          uid,
+		DN:          dn,
+		NewSuperior: parentDN(quarantineDN),
+		Attributes: []AttributeChange{
+			{Name: "description", Old: description, New: append(append([]string(nil), description...), quarantineStamp(entityType, time.Now()))},
+			{Name: "nsAccountLock", Old: attributeValues(current, "nsAccountLock"), New: []string{"TRUE"}},
+		},
+		Fingerprint: fingerprintEntry(current),
+	}, nil
+}
+
+// restoreChange returns the change that moves a quarantined entry back into its enforced OU,
+// removes the quarantine stamp, and makes its attributes match the configuration.
+// The quarantine set nsAccountLock, so it is treated as owned here: the entry is unlocked
+// unless the configuration disables it.
+func restoreChange(entityType, id, dn, quarantineDN string, quarantined, desired map[string][]string, owned []string) *EntityChange {
+	// Diff against the entry as it will look once the quarantine markers are gone
+	cleaned := make(map[string][]string, len(quarantined))
+	var description []string
+	var remaining []string
+	for name, values := range quarantined {
+		switch strings.ToLower(name) {
+		case "description":
+			description = values
+			for _, value := range values {
+				if !isQuarantineStamp(value) {
+					remaining = append(remaining, value)
+				}
+			}
+			if len(remaining) > 0 {
+				cleaned[name] = remaining
+			}
+			continue
+		}
+		cleaned[name] = values
+	}
+
+	owned = append(append([]string(nil), owned...), "nsAccountLock")
+	changes := diffAttributes(cleaned, desired, owned)
+
+	// The stamp must go even when the configured description did not change
+	descriptionChanged := false
+	for _, change := range changes {
+		if strings.EqualFold(change.Name, "description") {
+			descriptionChanged = true
+		}
+	}
+	if !descriptionChanged {
+		changes = append(changes, AttributeChange{Name: "description", Old: description, New: remaining})
+	}
+
+	return &EntityChange{
+		Action:      ChangeRestore,
+		EntityType:  entityType,
+		ID:          id,
+		DN:          quarantineDN,
+		NewSuperior: parentDN(dn),
+		Attributes:  changes,
+		Fingerprint: fingerprintEntry(quarantined),
+	}
+}
+
+// planQuarantinePurges returns purge changes for quarantined entries that are older than the retention period.
+// Entries that are back in the configuration are skipped because they will be restored instead.
+func planQuarantinePurges(c LDAPClientInterface, cfg *config.Config, now time.Time) ([]*EntityChange, error) {
+	retention, err := cfg.GetQuarantineRetention()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.LDAPEnforcer.QuarantineOU == "" || retention == 0 {
+		return nil, nil
+	}
+
+	existing, err := c.GetExistingEntries(cfg.LDAPEnforcer.QuarantineOU, "person")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quarantined entries: %w", err)
+	}
+
+	var purges []*EntityChange
+	for _, dn := range sortedKeys(existing) {
+		attrs, err := c.GetEntryAttributes(dn)
+		if err != nil {
+			return nil, err
+		}
+		entityType, at, ok := parseQuarantineStamp(attrs)
+		if !ok {
+			logging.DefaultLogger.Debug("Ignoring entry without a quarantine stamp: %s", dn)
+			continue
+		}
+
+		uid := getRDNValue(dn)
+		if _, ok := cfg.LDAPEnforcer.Person[uid]; ok && entityType == "person" {
+			continue
+		}
+		if _, ok := cfg.LDAPEnforcer.SvcAcct[uid]; ok && entityType == "svcacct" {
+			continue
+		}
+
+		if now.Sub(at) < retention {
+			continue
+		}
+		purges = append(purges, &EntityChange{
+			Action:      ChangePurge,
+			EntityType:  entityType,
+			ID:          uid,
+			DN:          dn,
+			Fingerprint: fingerprintEntry(attrs),
+		})
+	}
+
+	return purges, nil
+}
+
+// splitDN splits a DN into its first RDN and its parent DN, honoring escaped commas
+func splitDN(dn string) (rdn, parent string) {
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',':
+			return dn[:i], dn[i+1:]
+		}
+	}
+	return dn, ""
+}
+
+// parentDN returns the DN of the entry's parent
+func parentDN(dn string) string {
+	_, parent := splitDN(dn)
+	return parent
+}
@@ -0,0 +1,125 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mrled/ldapenforcer/internal/model"
+)
+
+func TestQuarantineRemovedPerson(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	testConfig.LDAPEnforcer.QuarantineOU = "ou=quarantine,dc=example,dc=com"
+	mockClient := NewMockClient(testConfig)
+
+	oldDN := mockClient.PersonToDN("olduser")
+	mockClient.Existing[oldDN] = true
+	mockClient.Entries[oldDN] = mockClient.GetPersonAttributes(&model.Person{Username: "olduser", CN: "Old User"})
+
+	if err := mockClient.SyncAll(); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+
+	quarantineDN := mockClient.QuarantineToDN("olduser")
+	if mockClient.Existing[oldDN] {
+		t.Error("Expected olduser to be moved out of the people OU")
+	}
+	if !mockClient.Existing[quarantineDN] {
+		t.Fatalf("Expected olduser to be moved to %s", quarantineDN)
+	}
+
+	attrs := mockClient.Entries[quarantineDN]
+	if lock := attributeValues(attrs, "nsAccountLock"); len(lock) != 1 || lock[0] != "TRUE" {
+		t.Errorf("Expected quarantined entry to be locked, got %v", lock)
+	}
+	entityType, at, ok := parseQuarantineStamp(attrs)
+	if !ok || entityType != "person" {
+		t.Fatalf("Expected a person quarantine stamp, got %v", attributeValues(attrs, "description"))
+	}
+	if time.Since(at) > time.Minute {
+		t.Errorf("Expected a recent quarantine timestamp, got %s", at)
+	}
+	for _, op := range mockClient.Operations {
+		if op.OpType == "delete" {
+			t.Errorf("Expected no deletions when a quarantine OU is configured, got %+v", op)
+		}
+	}
+
+	// Adding the person back restores the quarantined entry
+	testConfig.LDAPEnforcer.Person["olduser"] = &model.Person{CN: "Old User"}
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if plan.Count(ChangeRestore) != 1 || plan.Count(ChangeCreate) != 0 {
+		t.Fatalf("Expected a single restore and no creates, got %+v", plan.Changes)
+	}
+	if err := mockClient.ApplyPlan(plan); err != nil {
+		t.Fatalf("ApplyPlan failed: %v", err)
+	}
+
+	if !mockClient.Existing[oldDN] || mockClient.Existing[quarantineDN] {
+		t.Fatal("Expected olduser to be moved back to the people OU")
+	}
+	attrs = mockClient.Entries[oldDN]
+	if len(attributeValues(attrs, "nsAccountLock")) != 0 {
+		t.Error("Expected restored entry to be unlocked")
+	}
+	if _, _, ok := parseQuarantineStamp(attrs); ok {
+		t.Error("Expected the quarantine stamp to be removed")
+	}
+
+	plan, err = mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if plan.HasChanges() {
+		t.Errorf("Expected no changes after restoring, got %+v", plan.Changes)
+	}
+}
+
+func TestQuarantinePurge(t *testing.T) {
+	testConfig := newPlanTestConfig()
+	testConfig.LDAPEnforcer.QuarantineOU = "ou=quarantine,dc=example,dc=com"
+	testConfig.LDAPEnforcer.QuarantineRetention = "720h"
+	mockClient := NewMockClient(testConfig)
+
+	entries := map[string][]string{
+		"expired": {quarantineStamp("person", time.Now().Add(-1000*time.Hour))},
+		"recent":  {quarantineStamp("svcacct", time.Now().Add(-time.Hour))},
+		"foreign": {"Not quarantined by ldapenforcer"},
+	}
+	for uid, description := range entries {
+		dn := mockClient.QuarantineToDN(uid)
+		mockClient.Existing[dn] = true
+		mockClient.Entries[dn] = map[string][]string{"description": description}
+	}
+
+	plan, err := mockClient.PlanSync()
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	var purged []string
+	for _, change := range plan.Changes {
+		if change.Action == ChangePurge {
+			purged = append(purged, change.ID)
+		}
+	}
+	if len(purged) != 1 || purged[0] != "expired" {
+		t.Errorf("Expected only the expired entry to be purged, got %v", purged)
+	}
+
+	// Purges are not subject to the deletion limits
+	testConfig.LDAPEnforcer.MaxDeletions = 0
+	testConfig.LDAPEnforcer.MaxDeletionPercent = 1
+	if err := mockClient.ApplyPlan(plan); err != nil {
+		t.Fatalf("ApplyPlan failed: %v", err)
+	}
+	if mockClient.Existing[mockClient.QuarantineToDN("expired")] {
+		t.Error("Expected the expired entry to be purged")
+	}
+	if !mockClient.Existing[mockClient.QuarantineToDN("recent")] || !mockClient.Existing[mockClient.QuarantineToDN("foreign")] {
+		t.Error("Expected the recent and foreign entries to be kept")
+	}
+}
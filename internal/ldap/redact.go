@@ -0,0 +1,58 @@
+package ldap
+
+import (
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/mrled/ldapenforcer/internal/model"
+)
+
+// secretAttributes are attributes whose values are never logged or displayed (lowercase)
+var secretAttributes = map[string]bool{
+	"userpassword": true,
+}
+
+// RedactValues returns the values of an attribute, with the values of secret attributes replaced
+func RedactValues(name string, values []string) []string {
+	if !secretAttributes[strings.ToLower(name)] || len(values) == 0 {
+		return values
+	}
+	return []string{model.RedactedPassword}
+}
+
+// RedactAttributeChanges returns a copy of attribute changes with the values of secret attributes replaced
+func RedactAttributeChanges(changes []AttributeChange) []AttributeChange {
+	if changes == nil {
+		return nil
+	}
+	redacted := make([]AttributeChange, len(changes))
+	for i, change := range changes {
+		redacted[i] = AttributeChange{
+			Name: change.Name,
+			Old:  RedactValues(change.Name, change.Old),
+			New:  RedactValues(change.Name, change.New),
+		}
+	}
+	return redacted
+}
+
+// redactAttributes returns a copy of an attribute map with the values of secret attributes replaced, for logging
+func redactAttributes(attrs map[string][]string) map[string][]string {
+	if attrs == nil {
+		return nil
+	}
+	redacted := make(map[string][]string, len(attrs))
+	for name, values := range attrs {
+		redacted[name] = RedactValues(name, values)
+	}
+	return redacted
+}
+
+// entryAttributes returns the attributes of a search result entry as a map
+func entryAttributes(entry *ldap.Entry) map[string][]string {
+	attrs := make(map[string][]string, len(entry.Attributes))
+	for _, attr := range entry.Attributes {
+		attrs[attr.Name] = attr.Values
+	}
+	return attrs
+}
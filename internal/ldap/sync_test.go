@@ -68,7 +68,7 @@ func TestSyncWorkflow(t *testing.T) {
 	client := NewMockClient(testConfig)
 
 	// 1. Validate people attributes
-	johnAttrs := GetPersonAttributes(testConfig.LDAPEnforcer.Person["john"])
+	johnAttrs := client.GetPersonAttributes(testConfig.LDAPEnforcer.Person["john"])
 	if johnAttrs["cn"] == nil || johnAttrs["cn"][0] != "John Doe" {
 		t.Errorf("Expected CN attribute to be 'John Doe', got %v", johnAttrs["cn"])
 	}
@@ -77,7 +77,7 @@ func TestSyncWorkflow(t *testing.T) {
 	}
 
 	// 2. Validate service account attributes
-	backupAttrs := GetSvcAcctAttributes(testConfig.LDAPEnforcer.SvcAcct["backup"])
+	backupAttrs := client.GetSvcAcctAttributes(testConfig.LDAPEnforcer.SvcAcct["backup"])
 	if backupAttrs["description"] == nil || backupAttrs["description"][0] != "Backup service for system files" {
 		t.Errorf("Expected description attribute to be 'Backup service for system files', got %v", backupAttrs["description"])
 	}
@@ -98,7 +98,7 @@ func TestSyncWorkflow(t *testing.T) {
 	// 5. Simulate person sync
 	for uid, person := range testConfig.LDAPEnforcer.Person {
 		dn := client.PersonToDN(uid)
-		attrs := GetPersonAttributes(person)
+		attrs := client.GetPersonAttributes(person)
 		attrs["uid"] = []string{uid} // Add UID attribute for creation
 		t.Logf("Would create/update person: %s", dn)
 	}
@@ -106,7 +106,7 @@ func TestSyncWorkflow(t *testing.T) {
 	// 6. Simulate service account sync
 	for uid, svcacct := range testConfig.LDAPEnforcer.SvcAcct {
 		dn := client.SvcAcctToDN(uid)
-		attrs := GetSvcAcctAttributes(svcacct)
+		attrs := client.GetSvcAcctAttributes(svcacct)
 		attrs["uid"] = []string{uid} // Add UID attribute for creation
 		t.Logf("Would create/update service account: %s", dn)
 	}
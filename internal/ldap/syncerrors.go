@@ -0,0 +1,127 @@
+package ldap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EntityError records why a single entity failed to sync
+type EntityError struct {
+	// EntityType is the type of entity (person, svcacct, group)
+	EntityType string
+
+	// ID is the uid or group name of the entity, or its DN for entries that are not in the config
+	ID string
+
+	// DN of the entry
+	DN string
+
+	// Skipped is true if the entity was not attempted because an entity it depends on failed
+	Skipped bool
+
+	// Err is the reason the entity failed or was skipped
+	Err error
+}
+
+// Error implements the error interface
+func (e *EntityError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *EntityError) Unwrap() error {
+	return e.Err
+}
+
+// SyncErrors is returned by a continue-on-error sync and lists every entity that failed or was skipped
+type SyncErrors struct {
+	Errors []*EntityError
+}
+
+// Error implements the error interface, listing each failed entity on its own line
+func (e *SyncErrors) Error() string {
+	var failed, skipped int
+	for _, entityErr := range e.Errors {
+		if entityErr.Skipped {
+			skipped++
+		} else {
+			failed++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "sync finished with %d failed and %d skipped entities:", failed, skipped)
+	for _, entityErr := range e.Errors {
+		status := "failed"
+		if entityErr.Skipped {
+			status = "skipped"
+		}
+		fmt.Fprintf(&b, "\n  %s %s %s: %v", status, entityErr.EntityType, entityErr.ID, entityErr.Err)
+	}
+	return b.String()
+}
+
+// Unwrap returns the individual entity errors, so that errors.Is and errors.As see each of them
+func (e *SyncErrors) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, entityErr := range e.Errors {
+		errs[i] = entityErr
+	}
+	return errs
+}
+
+// syncRun tracks failures while a sync executes.
+// When continueOnError is false, the first failure stops the sync as before.
+type syncRun struct {
+	continueOnError bool
+	errors          []*EntityError
+
+	// Lowercased DNs of entries that should exist after the sync but failed to be created
+	missing map[string]bool
+}
+
+func newSyncRun(continueOnError bool) *syncRun {
+	return &syncRun{
+		continueOnError: continueOnError,
+		missing:         make(map[string]bool),
+	}
+}
+
+// fail records a failed entity and returns the error if the sync should stop.
+// creating marks entities whose entry does not exist yet, so that their dependents are skipped.
+func (r *syncRun) fail(entityType, id, dn string, creating bool, err error) error {
+	if !r.continueOnError {
+		return err
+	}
+	r.errors = append(r.errors, &EntityError{EntityType: entityType, ID: id, DN: dn, Err: err})
+	if creating {
+		r.missing[strings.ToLower(dn)] = true
+	}
+	return nil
+}
+
+// skip records an entity that was not attempted because an entity it depends on failed
+func (r *syncRun) skip(entityType, id, dn string, creating bool, err error) {
+	r.errors = append(r.errors, &EntityError{EntityType: entityType, ID: id, DN: dn, Skipped: true, Err: err})
+	if creating {
+		r.missing[strings.ToLower(dn)] = true
+	}
+}
+
+// missingMember returns the first member DN that failed to be created, or an empty string
+func (r *syncRun) missingMember(members []string) string {
+	for _, member := range members {
+		if r.missing[strings.ToLower(member)] {
+			return member
+		}
+	}
+	return ""
+}
+
+// err returns the aggregated errors, or nil if every entity synced
+func (r *syncRun) err() error {
+	if len(r.errors) == 0 {
+		return nil
+	}
+	return &SyncErrors{Errors: r.errors}
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// expiresDateLayout is the date-only form of the expires field
+const expiresDateLayout = "2006-01-02"
+
+// ShadowExpireDisabled is the shadowExpire value for a disabled POSIX account:
+// the day after the epoch, which is always in the past
+const ShadowExpireDisabled = 1
+
+// ParseExpires parses an expires value, either a date like "2026-12-31" or an RFC 3339 timestamp.
+// A date expires at the start of that day, UTC.
+func ParseExpires(expires string) (time.Time, error) {
+	if t, err := time.Parse(expiresDateLayout, expires); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, expires)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expires %q: must be a date like 2026-12-31 or an RFC 3339 timestamp", expires)
+	}
+	return t.UTC(), nil
+}
+
+// accountStatus holds the fields shared by people and service accounts that control whether the account is locked
+type accountStatus struct {
+	disabled *bool
+	expires  string
+}
+
+// isDisabled returns true if the account is explicitly disabled
+func (a accountStatus) isDisabled() bool {
+	return a.disabled != nil && *a.disabled
+}
+
+// managesLock returns true if the account sets disabled (to either value) or an expiry time
+func (a accountStatus) managesLock() bool {
+	return a.disabled != nil || a.expires != ""
+}
+
+// locked returns true if the account is disabled, or its expiry time is not after now
+func (a accountStatus) locked(now time.Time) bool {
+	if a.isDisabled() {
+		return true
+	}
+	if expiresAt, ok := a.expiresAt(); ok {
+		return !now.Before(expiresAt)
+	}
+	return false
+}
+
+// expiresAt returns the parsed expiry time, if one is set and valid
+func (a accountStatus) expiresAt() (time.Time, bool) {
+	if a.expires == "" {
+		return time.Time{}, false
+	}
+	t, err := ParseExpires(a.expires)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// shadowExpire returns the shadowExpire value for the account in days since the epoch,
+// or 0 if the account is neither disabled nor has an expiry time
+func (a accountStatus) shadowExpire() int {
+	if a.isDisabled() {
+		return ShadowExpireDisabled
+	}
+	if expiresAt, ok := a.expiresAt(); ok {
+		days := int(expiresAt.Unix() / 86400)
+		if expiresAt.Unix()%86400 != 0 {
+			// Round up, so that shadow does not expire the account before the expiry time
+			days++
+		}
+		return days
+	}
+	return 0
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExpires(t *testing.T) {
+	tests := []struct {
+		expires     string
+		expected    time.Time
+		expectError bool
+	}{
+		{"2026-12-31", time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC), false},
+		{"2026-12-31T18:00:00-05:00", time.Date(2026, 12, 31, 23, 0, 0, 0, time.UTC), false},
+		{"31/12/2026", time.Time{}, true},
+		{"", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseExpires(tt.expires)
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("Expected an error for %q but got none", tt.expires)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Did not expect an error for %q but got: %v", tt.expires, err)
+			continue
+		}
+		if !got.Equal(tt.expected) {
+			t.Errorf("Expected %s for %q, got %s", tt.expected, tt.expires, got)
+		}
+	}
+}
+
+func TestPersonIsLocked(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	enabled, disabled := false, true
+
+	tests := []struct {
+		name         string
+		person       Person
+		locked       bool
+		managesLock  bool
+		shadowExpire int
+	}{
+		{"active", Person{}, false, false, 0},
+		{"disabled", Person{Disabled: &disabled}, true, true, ShadowExpireDisabled},
+		{"explicitly enabled", Person{Disabled: &enabled}, false, true, 0},
+		{"expires later", Person{Expires: "2026-12-31"}, false, true, 20818},
+		{"expired", Person{Expires: "2026-01-01"}, true, true, 20454},
+		{"expires today", Person{Expires: "2026-06-01"}, true, true, 20605},
+		{"disabled with a later expiry", Person{Disabled: &disabled, Expires: "2026-12-31"}, true, true, ShadowExpireDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.person.IsLocked(now); got != tt.locked {
+				t.Errorf("Expected IsLocked to be %v, got %v", tt.locked, got)
+			}
+			if got := tt.person.ManagesLock(); got != tt.managesLock {
+				t.Errorf("Expected ManagesLock to be %v, got %v", tt.managesLock, got)
+			}
+			if got := tt.person.GetShadowExpire(); got != tt.shadowExpire {
+				t.Errorf("Expected shadowExpire %d, got %d", tt.shadowExpire, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// reservedAttributes are generated or maintained by ldapenforcer or the directory server,
+// and cannot be set through the attributes table.
+// Keys are lowercase.
+var reservedAttributes = map[string]bool{
+	"objectclass":   true,
+	"uid":           true,
+	"cn":            true,
+	"sn":            true,
+	"givenname":     true,
+	"mail":          true,
+	"description":   true,
+	"uidnumber":     true,
+	"gidnumber":     true,
+	"homedirectory": true,
+	"loginshell":    true,
+	"sshpublickey":  true,
+	"userpassword":  true,
+	"member":        true,
+	"memberuid":     true,
+	"memberof":      true,
+	"nsaccountlock": true,
+	"shadowexpire":  true,
+}
+
+// attributeNamePattern matches an LDAP attribute description: a letter followed by letters, digits, and hyphens
+var attributeNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+
+// IsReservedAttribute returns true if an attribute cannot be set through the attributes table
+func IsReservedAttribute(name string) bool {
+	return reservedAttributes[strings.ToLower(name)]
+}
+
+// ValidateExtraAttributes checks an entity's attributes table and extra_object_classes list
+func ValidateExtraAttributes(attributes map[string][]string, objectClasses []string) error {
+	seen := make(map[string]string, len(attributes))
+	for name, values := range attributes {
+		if !attributeNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid attribute name %q", name)
+		}
+		if IsReservedAttribute(name) {
+			return fmt.Errorf("attribute %s is reserved and cannot be set in attributes", name)
+		}
+		lower := strings.ToLower(name)
+		if other, ok := seen[lower]; ok {
+			return fmt.Errorf("attribute %s is set twice (as %s and %s)", name, other, name)
+		}
+		seen[lower] = name
+		if len(values) == 0 {
+			return fmt.Errorf("attribute %s must have at least one value", name)
+		}
+		for _, value := range values {
+			if value == "" {
+				return fmt.Errorf("attribute %s must not have an empty value", name)
+			}
+		}
+	}
+
+	for _, objectClass := range objectClasses {
+		if !attributeNamePattern.MatchString(objectClass) {
+			return fmt.Errorf("invalid object class %q", objectClass)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package model
+
+import "testing"
+
+func TestValidateExtraAttributes(t *testing.T) {
+	tests := []struct {
+		name          string
+		attributes    map[string][]string
+		objectClasses []string
+		expectError   bool
+	}{
+		{"empty", nil, nil, false},
+		{"valid", map[string][]string{"title": {"Engineer"}, "telephoneNumber": {"+1 555 0100", "+1 555 0101"}}, []string{"customPerson"}, false},
+		{"reserved uid", map[string][]string{"uid": {"someone"}}, nil, true},
+		{"reserved member in another case", map[string][]string{"Member": {"uid=x,dc=example,dc=com"}}, nil, true},
+		{"reserved objectClass", map[string][]string{"objectClass": {"extensibleObject"}}, nil, true},
+		{"same attribute twice", map[string][]string{"title": {"a"}, "Title": {"b"}}, nil, true},
+		{"no values", map[string][]string{"title": {}}, nil, true},
+		{"empty value", map[string][]string{"title": {""}}, nil, true},
+		{"invalid name", map[string][]string{"employee number": {"1"}}, nil, true},
+		{"invalid object class", nil, []string{""}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateExtraAttributes(tt.attributes, tt.objectClasses)
+			if tt.expectError && err == nil {
+				t.Errorf("Expected an error but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Did not expect an error but got: %v", err)
+			}
+		})
+	}
+}
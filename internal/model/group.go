@@ -1,5 +1,19 @@
 package model
 
+import "fmt"
+
+// Nesting modes control how a group lists the groups in its groups list
+const (
+	// NestingFlatten lists the members of nested groups as direct members (the default)
+	NestingFlatten = "flatten"
+
+	// NestingReference lists the DNs of nested groups as members
+	NestingReference = "reference"
+
+	// NestingBoth lists both the DNs of nested groups and their members
+	NestingBoth = "both"
+)
+
 // Group represents an LDAP group
 type Group struct {
 	// Description (required)
@@ -9,6 +23,9 @@ type Group struct {
 	// If set, indicates this is a POSIX group
 	PosixGidNumber int `toml:"posixGidNumber,omitempty"`
 
+	// Allocate the POSIX GID number from gid_range instead of setting posixGidNumber (optional)
+	AutoPosix bool `toml:"auto_posix,omitempty"`
+
 	// List of people in this group
 	People []string `toml:"people,omitempty"`
 
@@ -17,9 +34,43 @@ type Group struct {
 
 	// List of groups whose members should be included in this group
 	Groups []string `toml:"groups,omitempty"`
+
+	// Rules selecting people and service accounts by their labels, as a table or an array of tables (optional)
+	Include MemberRules `toml:"include,omitempty"`
+
+	// Include every person (optional)
+	IncludeAllPeople bool `toml:"include_all_people,omitempty"`
+
+	// DNs of entries outside the enforced OUs to include in member (optional)
+	// They must already exist; ldapenforcer never creates or deletes them
+	ExternalMembers []string `toml:"external_members,omitempty"`
+
+	// Keep the group when it has no members, using the empty_group_placeholder as its only member (optional)
+	AllowEmpty bool `toml:"allow_empty,omitempty"`
+
+	// How nested groups are represented in member, overriding the global group_nesting (optional)
+	Nesting string `toml:"nesting,omitempty"`
+
+	// Additional LDAP attributes, as attribute name to values (optional)
+	Attributes map[string][]string `toml:"attributes,omitempty"`
+
+	// Additional object classes, e.g. for a custom schema (optional)
+	ExtraObjectClasses []string `toml:"extra_object_classes,omitempty"`
+
+	// Private marks a user private group generated for a person; it is never read from the configuration
+	Private bool `toml:"-" json:"-"`
 }
 
 // IsPosix returns true if the group has a POSIX GID number
 func (g *Group) IsPosix() bool {
 	return g.PosixGidNumber > 0
 }
+
+// ValidateNesting returns an error unless nesting is empty or a known nesting mode
+func ValidateNesting(nesting string) error {
+	switch nesting {
+	case "", NestingFlatten, NestingReference, NestingBoth:
+		return nil
+	}
+	return fmt.Errorf("invalid nesting %q: must be %q, %q, or %q", nesting, NestingFlatten, NestingReference, NestingBoth)
+}
@@ -1,14 +1,19 @@
 package model
 
+import (
+	"slices"
+	"sort"
+)
+
 // Member represents a member of a group
 type Member struct {
 	// DN is the distinguished name of the member
 	DN string
 
-	// Type is the type of member (person, svcacct, group)
+	// Type is the type of member (person, svcacct, group, external)
 	Type string
 
-	// UID is the uid attribute of the member
+	// UID is the uid attribute of the member (the group name for groups, empty for external members)
 	UID string
 
 	// IsPosix indicates if the member is a POSIX account
@@ -32,7 +37,7 @@ func GetGroupMembers(groupname string, groups map[string]*Group, people map[stri
 	var members []*Member
 
 	// Process direct people members
-	for _, uid := range group.People {
+	for _, uid := range groupPeople(groupname, group, people) {
 		person, ok := people[uid]
 		if !ok {
 			continue
@@ -47,7 +52,7 @@ func GetGroupMembers(groupname string, groups map[string]*Group, people map[stri
 	}
 
 	// Process direct service account members
-	for _, uid := range group.SvcAccts {
+	for _, uid := range groupSvcAccts(groupname, group, svcaccts) {
 		svcacct, ok := svcaccts[uid]
 		if !ok {
 			continue
@@ -61,6 +66,9 @@ func GetGroupMembers(groupname string, groups map[string]*Group, people map[stri
 		})
 	}
 
+	// Process external members
+	members = append(members, externalMembers(group)...)
+
 	// Process nested groups
 	processedGroups[groupname] = true
 	for _, nestedGroupName := range group.Groups {
@@ -107,7 +115,7 @@ func getNestedGroupMembers(groupname string, groups map[string]*Group, people ma
 	var members []*Member
 
 	// Process direct people members
-	for _, uid := range group.People {
+	for _, uid := range groupPeople(groupname, group, people) {
 		person, ok := people[uid]
 		if !ok {
 			continue
@@ -122,7 +130,7 @@ func getNestedGroupMembers(groupname string, groups map[string]*Group, people ma
 	}
 
 	// Process direct service account members
-	for _, uid := range group.SvcAccts {
+	for _, uid := range groupSvcAccts(groupname, group, svcaccts) {
 		svcacct, ok := svcaccts[uid]
 		if !ok {
 			continue
@@ -136,6 +144,9 @@ func getNestedGroupMembers(groupname string, groups map[string]*Group, people ma
 		})
 	}
 
+	// Process external members
+	members = append(members, externalMembers(group)...)
+
 	// Process nested groups (recursively)
 	for _, nestedGroupName := range group.Groups {
 		if processedGroups[nestedGroupName] {
@@ -164,6 +175,133 @@ func getNestedGroupMembers(groupname string, groups map[string]*Group, people ma
 	return members, nil
 }
 
+// ResolveGroupMembers returns the members to list in a group's member attribute for a nesting mode.
+// flatten returns the same members as GetGroupMembers; reference returns the direct people and service accounts
+// plus the nested groups themselves; both returns the flattened members plus the nested groups.
+// Nested groups that resolve to no members are not referenced, because they are not created, unless they have allow_empty.
+// Referenced user private groups are in privateGroupOU.
+func ResolveGroupMembers(groupname, nesting string, groups map[string]*Group, people map[string]*Person, svcaccts map[string]*SvcAcct,
+	enforcedPeopleOU, enforcedSvcAcctOU, enforcedGroupOU, privateGroupOU string) ([]*Member, error) {
+
+	if nesting == "" || nesting == NestingFlatten {
+		return GetGroupMembers(groupname, groups, people, svcaccts, enforcedPeopleOU, enforcedSvcAcctOU, enforcedGroupOU)
+	}
+
+	group, ok := groups[groupname]
+	if !ok {
+		return nil, nil
+	}
+
+	var members []*Member
+	if nesting == NestingBoth {
+		flattened, err := GetGroupMembers(groupname, groups, people, svcaccts, enforcedPeopleOU, enforcedSvcAcctOU, enforcedGroupOU)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, flattened...)
+	} else {
+		for _, uid := range groupPeople(groupname, group, people) {
+			if person, ok := people[uid]; ok {
+				members = append(members, &Member{
+					DN:      createPersonDN(uid, enforcedPeopleOU),
+					Type:    "person",
+					UID:     uid,
+					IsPosix: person.IsPosix(),
+				})
+			}
+		}
+		for _, uid := range groupSvcAccts(groupname, group, svcaccts) {
+			if svcacct, ok := svcaccts[uid]; ok {
+				members = append(members, &Member{
+					DN:      createSvcAcctDN(uid, enforcedSvcAcctOU),
+					Type:    "svcacct",
+					UID:     uid,
+					IsPosix: svcacct.IsPosix(),
+				})
+			}
+		}
+		members = append(members, externalMembers(group)...)
+	}
+
+	for _, nestedGroupName := range group.Groups {
+		if nestedGroupName == groupname {
+			continue
+		}
+		nestedMembers, err := GetGroupMembers(nestedGroupName, groups, people, svcaccts, enforcedPeopleOU, enforcedSvcAcctOU, enforcedGroupOU)
+		if err != nil {
+			return nil, err
+		}
+		// Empty nested groups are not created, unless they are allowed to be empty
+		nestedGroup, ok := groups[nestedGroupName]
+		if !ok || (len(nestedMembers) == 0 && !nestedGroup.AllowEmpty) {
+			continue
+		}
+		nestedGroupOU := enforcedGroupOU
+		if nestedGroup.Private {
+			nestedGroupOU = privateGroupOU
+		}
+		members = append(members, &Member{
+			DN:   createGroupDN(nestedGroupName, nestedGroupOU),
+			Type: "group",
+			UID:  nestedGroupName,
+		})
+	}
+
+	return members, nil
+}
+
+// groupPeople returns the uids of the people directly in a group:
+// its people list, followed by the people that list the group in their groups list,
+// that have it as their primary group with primary_group_member,
+// or that its include rules select, sorted
+func groupPeople(groupname string, group *Group, people map[string]*Person) []string {
+	uids := append([]string(nil), group.People...)
+	seen := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		seen[uid] = true
+	}
+	var declared []string
+	for uid, person := range people {
+		if !seen[uid] && (slices.Contains(person.Groups, groupname) || person.IsPrimaryGroupMember(groupname) || group.includesPerson(person)) {
+			declared = append(declared, uid)
+		}
+	}
+	sort.Strings(declared)
+	return append(uids, declared...)
+}
+
+// groupSvcAccts returns the uids of the service accounts directly in a group:
+// its svcaccts list, followed by the service accounts that list the group in their groups list,
+// that have it as their primary group with primary_group_member,
+// or that its include rules select, sorted
+func groupSvcAccts(groupname string, group *Group, svcaccts map[string]*SvcAcct) []string {
+	uids := append([]string(nil), group.SvcAccts...)
+	seen := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		seen[uid] = true
+	}
+	var declared []string
+	for uid, svcacct := range svcaccts {
+		if !seen[uid] && (slices.Contains(svcacct.Groups, groupname) || svcacct.IsPrimaryGroupMember(groupname) || group.includesSvcAcct(svcacct)) {
+			declared = append(declared, uid)
+		}
+	}
+	sort.Strings(declared)
+	return append(uids, declared...)
+}
+
+// externalMembers returns the external members of a group, which are listed by DN
+func externalMembers(group *Group) []*Member {
+	var members []*Member
+	for _, dn := range group.ExternalMembers {
+		members = append(members, &Member{
+			DN:   dn,
+			Type: "external",
+		})
+	}
+	return members
+}
+
 // Helper functions to create DNs
 func createPersonDN(uid, enforcedPeopleOU string) string {
 	return "uid=" + uid + "," + enforcedPeopleOU
@@ -172,3 +310,7 @@ func createPersonDN(uid, enforcedPeopleOU string) string {
 func createSvcAcctDN(uid, enforcedSvcAcctOU string) string {
 	return "uid=" + uid + "," + enforcedSvcAcctOU
 }
+
+func createGroupDN(groupname, enforcedGroupOU string) string {
+	return "cn=" + groupname + "," + enforcedGroupOU
+}
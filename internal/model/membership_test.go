@@ -1,6 +1,7 @@
 package model
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -103,3 +104,209 @@ func TestGetGroupMembers(t *testing.T) {
 		t.Errorf("Expected 0 members in cyclic group, got %d", len(cyclicMembers))
 	}
 }
+
+func TestResolveGroupMembers(t *testing.T) {
+	people := map[string]*Person{
+		"user1": {CN: "User One"},
+		"user2": {CN: "User Two"},
+	}
+	groups := map[string]*Group{
+		"child": {
+			Description: "Child",
+			People:      []string{"user1"},
+		},
+		"empty": {
+			Description: "Empty",
+		},
+		"allowed": {
+			Description: "Empty but kept",
+			AllowEmpty:  true,
+		},
+		"parent": {
+			Description: "Parent",
+			People:      []string{"user2"},
+			Groups:      []string{"child", "empty", "allowed"},
+		},
+	}
+	peopleOU := "ou=people,dc=example,dc=com"
+	groupOU := "ou=groups,dc=example,dc=com"
+
+	tests := []struct {
+		nesting string
+		dns     []string
+	}{
+		{NestingFlatten, []string{"uid=user2," + peopleOU, "uid=user1," + peopleOU}},
+		{"", []string{"uid=user2," + peopleOU, "uid=user1," + peopleOU}},
+		// The empty group is never created, so it is not referenced, but the group with allow_empty is
+		{NestingReference, []string{"uid=user2," + peopleOU, "cn=child," + groupOU, "cn=allowed," + groupOU}},
+		{NestingBoth, []string{"uid=user2," + peopleOU, "uid=user1," + peopleOU, "cn=child," + groupOU, "cn=allowed," + groupOU}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.nesting, func(t *testing.T) {
+			members, err := ResolveGroupMembers("parent", tt.nesting, groups, people, nil, peopleOU, "ou=svcaccts,dc=example,dc=com", groupOU, groupOU)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var dns []string
+			for _, member := range members {
+				dns = append(dns, member.DN)
+			}
+			if len(dns) != len(tt.dns) {
+				t.Fatalf("Expected members %v, got %v", tt.dns, dns)
+			}
+			for i := range dns {
+				if dns[i] != tt.dns[i] {
+					t.Errorf("Expected members %v, got %v", tt.dns, dns)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestResolveGroupMembersPrivateGroup(t *testing.T) {
+	people := map[string]*Person{
+		"user1": {CN: "User One", Posix: []int{1001, 1001}},
+	}
+	groups := map[string]*Group{
+		"user1": {
+			Description:    "User private group for user1",
+			PosixGidNumber: 1001,
+			People:         []string{"user1"},
+			Private:        true,
+		},
+		"parent": {
+			Description: "Parent",
+			Groups:      []string{"user1"},
+		},
+	}
+	groupOU := "ou=groups,dc=example,dc=com"
+	privateGroupOU := "ou=upg,dc=example,dc=com"
+
+	members, err := ResolveGroupMembers("parent", NestingReference, groups, people, nil,
+		"ou=people,dc=example,dc=com", "ou=svcaccts,dc=example,dc=com", groupOU, privateGroupOU)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(members) != 1 || members[0].DN != "cn=user1,"+privateGroupOU {
+		t.Errorf("Expected the private group to be referenced in its own OU, got %+v", members)
+	}
+}
+
+func TestValidateNesting(t *testing.T) {
+	for _, nesting := range []string{"", NestingFlatten, NestingReference, NestingBoth} {
+		if err := ValidateNesting(nesting); err != nil {
+			t.Errorf("Did not expect an error for %q but got: %v", nesting, err)
+		}
+	}
+	if err := ValidateNesting("nested"); err == nil {
+		t.Error("Expected an error for an unknown nesting mode")
+	}
+}
+
+func TestGetGroupMembersDeclaredOnAccounts(t *testing.T) {
+	people := map[string]*Person{
+		"user1": {CN: "User One"},
+		"user2": {CN: "User Two", Groups: []string{"staff"}},
+		// Listed on both sides, but only included once
+		"user3": {CN: "User Three", Groups: []string{"staff", "vpn"}},
+	}
+	svcaccts := map[string]*SvcAcct{
+		"svc1": {CN: "Service One", Description: "Service account 1", Groups: []string{"staff"}},
+	}
+	groups := map[string]*Group{
+		"staff": {Description: "Staff", People: []string{"user1", "user3"}},
+		"vpn":   {Description: "VPN users"},
+		"all":   {Description: "Everyone", Groups: []string{"staff"}},
+	}
+	peopleOU := "ou=people,dc=example,dc=com"
+	svcacctOU := "ou=svcaccts,dc=example,dc=com"
+	groupOU := "ou=groups,dc=example,dc=com"
+
+	tests := []struct {
+		group string
+		uids  []string
+	}{
+		{"staff", []string{"user1", "user3", "user2", "svc1"}},
+		{"vpn", []string{"user3"}},
+		// Declared members of nested groups are included too
+		{"all", []string{"user1", "user3", "user2", "svc1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.group, func(t *testing.T) {
+			members, err := GetGroupMembers(tt.group, groups, people, svcaccts, peopleOU, svcacctOU, groupOU)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var uids []string
+			for _, member := range members {
+				uids = append(uids, member.UID)
+			}
+			if len(uids) != len(tt.uids) {
+				t.Fatalf("Expected members %v, got %v", tt.uids, uids)
+			}
+			for i := range uids {
+				if uids[i] != tt.uids[i] {
+					t.Errorf("Expected members %v, got %v", tt.uids, uids)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestGetGroupMembersIncludeRules(t *testing.T) {
+	people := map[string]*Person{
+		"user1": {CN: "User One", Labels: map[string]string{"dept": "eng", "site": "remote"}},
+		"user2": {CN: "User Two", Labels: map[string]string{"dept": "eng"}},
+		"user3": {CN: "User Three", Labels: map[string]string{"dept": "sales"}},
+		"user4": {CN: "User Four"},
+	}
+	svcaccts := map[string]*SvcAcct{
+		"svc1": {CN: "Service One", Description: "Service account 1", Labels: map[string]string{"dept": "eng"}},
+	}
+	groups := map[string]*Group{
+		"eng": {Description: "Engineering", Include: MemberRules{{Kind: RuleKindPerson, Labels: map[string]string{"dept": "eng"}}}},
+		// Every label of a rule must match, and any rule can select an account
+		"remote-eng": {Description: "Remote engineers", Include: MemberRules{
+			{Kind: RuleKindPerson, Labels: map[string]string{"dept": "eng", "site": "remote"}},
+			{Kind: RuleKindSvcAcct, Labels: map[string]string{"dept": "eng"}},
+		}},
+		"everyone": {Description: "Everyone", IncludeAllPeople: true},
+		// Listed people come first and are only included once
+		"sales":  {Description: "Sales", People: []string{"user4", "user3"}, Include: MemberRules{{Kind: RuleKindPerson, Labels: map[string]string{"dept": "sales"}}}},
+		"nobody": {Description: "Nobody", Include: MemberRules{{Kind: RuleKindPerson, Labels: map[string]string{"dept": "legal"}}}},
+	}
+	peopleOU := "ou=people,dc=example,dc=com"
+	svcacctOU := "ou=svcaccts,dc=example,dc=com"
+	groupOU := "ou=groups,dc=example,dc=com"
+
+	tests := []struct {
+		group string
+		uids  []string
+	}{
+		{"eng", []string{"user1", "user2"}},
+		{"remote-eng", []string{"user1", "svc1"}},
+		{"everyone", []string{"user1", "user2", "user3", "user4"}},
+		{"sales", []string{"user4", "user3"}},
+		{"nobody", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.group, func(t *testing.T) {
+			members, err := GetGroupMembers(tt.group, groups, people, svcaccts, peopleOU, svcacctOU, groupOU)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var uids []string
+			for _, member := range members {
+				uids = append(uids, member.UID)
+			}
+			if !reflect.DeepEqual(uids, tt.uids) {
+				t.Errorf("Expected members %v, got %v", tt.uids, uids)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RedactedPassword replaces password hashes in logs and displayed configuration
+const RedactedPassword = "[REDACTED]"
+
+// passwordHashPattern matches a userPassword value with a storage scheme prefix, like "{SSHA512}..."
+var passwordHashPattern = regexp.MustCompile(`^\{([A-Za-z0-9_.-]+)\}.+$`)
+
+// plaintextSchemes are storage schemes that store the password as-is
+var plaintextSchemes = map[string]bool{
+	"CLEAR":     true,
+	"CLEARTEXT": true,
+	"PLAIN":     true,
+}
+
+// ValidatePasswordHash returns an error unless hash is a pre-hashed userPassword value,
+// such as "{SSHA512}..." or "{PBKDF2_SHA256}...".
+// The error never includes the value itself, which might be a plain-text password.
+func ValidatePasswordHash(hash string) error {
+	match := passwordHashPattern.FindStringSubmatch(hash)
+	if match == nil {
+		return fmt.Errorf("password hash must start with a storage scheme like {SSHA512}; plain-text passwords are not accepted")
+	}
+	if plaintextSchemes[strings.ToUpper(match[1])] {
+		return fmt.Errorf("password hash uses the plain-text scheme {%s}; plain-text passwords are not accepted", match[1])
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePasswordHash(t *testing.T) {
+	tests := []struct {
+		hash        string
+		expectError bool
+	}{
+		{"{SSHA512}dGhpcyBpcyBub3QgYSByZWFsIGhhc2g=", false},
+		{"{PBKDF2_SHA256}AAAIAFnD4ZDfuL6Ke6hXWlQ5", false},
+		{"{CRYPT}$6$salt$hash", false},
+		{"hunter2", true},
+		{"{CLEAR}hunter2", true},
+		{"{cleartext}hunter2", true},
+		{"{SSHA256}", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		err := ValidatePasswordHash(tt.hash)
+		if tt.expectError && err == nil {
+			t.Errorf("Expected an error for %q but got none", tt.hash)
+		}
+		if !tt.expectError && err != nil {
+			t.Errorf("Did not expect an error for %q but got: %v", tt.hash, err)
+		}
+		if err != nil && tt.hash != "" && strings.Contains(err.Error(), tt.hash) {
+			t.Errorf("Error for %q must not include the value: %v", tt.hash, err)
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package model
 
+import "time"
+
 // Person represents a person in LDAP
 type Person struct {
 	// Username (uid) - used for DN and home directory
 	// This is set from the map key in the configuration
 	Username string `toml:"-"`
 
+	// Template that supplies defaults for the fields this person does not set (optional)
+	Template string `toml:"template,omitempty"`
+
 	// Common name (CN)
 	CN string `toml:"cn"`
 
@@ -21,6 +26,54 @@ type Person struct {
 	// POSIX attributes: UID number, GID number (optional)
 	// If set, indicates this is a POSIX person
 	Posix []int `toml:"posix,omitempty"`
+
+	// Allocate POSIX UID and GID numbers from uid_range instead of setting posix (optional)
+	AutoPosix bool `toml:"auto_posix,omitempty"`
+
+	// POSIX group whose GID number is this person's GID number; posix then lists only the UID number (optional)
+	PrimaryGroup string `toml:"primary_group,omitempty"`
+
+	// Make this person a member of its primary group, overriding the global primary_group_member (optional)
+	PrimaryGroupMember *bool `toml:"primary_group_member,omitempty"`
+
+	// Create a user private group for this POSIX person, overriding the global user_private_group (optional)
+	UserPrivateGroup *bool `toml:"user_private_group,omitempty"`
+
+	// Login shell for POSIX accounts, overriding the global default (optional)
+	LoginShell string `toml:"login_shell,omitempty"`
+
+	// Home directory for POSIX accounts, overriding home_directory_base/<uid> (optional)
+	HomeDirectory string `toml:"home_directory,omitempty"`
+
+	// Lock the account without removing it, or unlock it with false (optional)
+	Disabled *bool `toml:"disabled,omitempty"`
+
+	// Lock the account from this date, like "2026-12-31", or RFC 3339 timestamp (optional)
+	Expires string `toml:"expires,omitempty"`
+
+	// Groups to add this account to, in addition to the groups that list it in people (optional)
+	Groups []string `toml:"groups,omitempty"`
+
+	// Labels that group include rules select accounts by, as name to value (optional)
+	Labels map[string]string `toml:"labels,omitempty"`
+
+	// Additional LDAP attributes, as attribute name to values (optional)
+	Attributes map[string][]string `toml:"attributes,omitempty"`
+
+	// Additional object classes, e.g. for a custom schema (optional)
+	ExtraObjectClasses []string `toml:"extra_object_classes,omitempty"`
+
+	// Pre-hashed userPassword value, like "{SSHA512}..." (optional)
+	PasswordHash string `toml:"password_hash,omitempty"`
+
+	// File containing the pre-hashed userPassword value, relative to the config file (optional)
+	PasswordFile string `toml:"password_file,omitempty"`
+
+	// SSH public keys in authorized_keys format (optional)
+	SSHKeys []string `toml:"ssh_keys,omitempty"`
+
+	// File of additional SSH public keys in authorized_keys format, relative to the config file (optional)
+	SSHKeysFile string `toml:"ssh_keys_file,omitempty"`
 }
 
 // IsPosix returns true if the person has POSIX attributes
@@ -57,3 +110,35 @@ func (p *Person) GetSN() string {
 	}
 	return ""
 }
+
+// IsLocked returns true if the person is disabled or has expired at the given time
+func (p *Person) IsLocked(now time.Time) bool {
+	return p.status().locked(now)
+}
+
+// IsPrimaryGroupMember returns true if groupname is the person's primary group and primary_group_member makes it a member.
+// The global primary_group_member is filled in by Config.ResolvePrimaryGroups.
+func (p *Person) IsPrimaryGroupMember(groupname string) bool {
+	return p.PrimaryGroup != "" && p.PrimaryGroup == groupname && p.PrimaryGroupMember != nil && *p.PrimaryGroupMember
+}
+
+// ManagesLock returns true if the person sets disabled or expires,
+// so that its nsAccountLock follows the configuration instead of being left alone
+func (p *Person) ManagesLock() bool {
+	return p.status().managesLock()
+}
+
+// ExpiresAt returns when the person expires, if it has a valid expiry time
+func (p *Person) ExpiresAt() (time.Time, bool) {
+	return p.status().expiresAt()
+}
+
+// GetShadowExpire returns the shadowExpire value for a POSIX person,
+// or 0 if the person is neither disabled nor has an expiry time
+func (p *Person) GetShadowExpire() int {
+	return p.status().shadowExpire()
+}
+
+func (p *Person) status() accountStatus {
+	return accountStatus{disabled: p.Disabled, expires: p.Expires}
+}
@@ -0,0 +1,137 @@
+package model
+
+import "fmt"
+
+// Kinds of account a membership rule selects
+const (
+	// RuleKindPerson selects people
+	RuleKindPerson = "person"
+
+	// RuleKindSvcAcct selects service accounts
+	RuleKindSvcAcct = "svcacct"
+)
+
+// MemberRule selects the accounts of one kind that have all of the given labels
+type MemberRule struct {
+	// Kind of account to select: "person" or "svcacct"
+	Kind string `toml:"kind"`
+
+	// Labels the account must have, with these values; no labels selects every account of the kind (optional)
+	Labels map[string]string `toml:"labels,omitempty"`
+}
+
+// MemberRules is a list of membership rules.
+// In a config file it can be a single table or an array of tables.
+type MemberRules []MemberRule
+
+// UnmarshalTOML decodes a single rule table or an array of rule tables
+func (r *MemberRules) UnmarshalTOML(data any) error {
+	var tables []any
+	switch value := data.(type) {
+	case map[string]any:
+		tables = []any{value}
+	case []map[string]any:
+		for _, table := range value {
+			tables = append(tables, table)
+		}
+	case []any:
+		tables = value
+	default:
+		return fmt.Errorf("include must be a table or an array of tables")
+	}
+
+	rules := make(MemberRules, 0, len(tables))
+	for _, table := range tables {
+		fields, ok := table.(map[string]any)
+		if !ok {
+			return fmt.Errorf("include must be a table or an array of tables")
+		}
+		rule, err := decodeMemberRule(fields)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+	*r = rules
+	return nil
+}
+
+// decodeMemberRule decodes the fields of a single rule table
+func decodeMemberRule(fields map[string]any) (MemberRule, error) {
+	var rule MemberRule
+	for key, value := range fields {
+		switch key {
+		case "kind":
+			kind, ok := value.(string)
+			if !ok {
+				return rule, fmt.Errorf("include rule kind must be a string")
+			}
+			rule.Kind = kind
+		case "labels":
+			labels, ok := value.(map[string]any)
+			if !ok {
+				return rule, fmt.Errorf("include rule labels must be a table")
+			}
+			rule.Labels = make(map[string]string, len(labels))
+			for name, labelValue := range labels {
+				text, ok := labelValue.(string)
+				if !ok {
+					return rule, fmt.Errorf("include rule label %s must be a string", name)
+				}
+				rule.Labels[name] = text
+			}
+		default:
+			return rule, fmt.Errorf("unknown include rule key %s", key)
+		}
+	}
+	return rule, nil
+}
+
+// Validate returns an error if the rule has an unknown kind or an empty label name
+func (r *MemberRule) Validate() error {
+	if r.Kind != RuleKindPerson && r.Kind != RuleKindSvcAcct {
+		return fmt.Errorf("invalid include rule kind %q: must be %q or %q", r.Kind, RuleKindPerson, RuleKindSvcAcct)
+	}
+	for name := range r.Labels {
+		if name == "" {
+			return fmt.Errorf("include rule has an empty label name")
+		}
+	}
+	return nil
+}
+
+// matches returns true if the rule selects an account of the given kind with the given labels
+func (r *MemberRule) matches(kind string, labels map[string]string) bool {
+	if r.Kind != kind {
+		return false
+	}
+	for name, value := range r.Labels {
+		if actual, ok := labels[name]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// includesPerson returns true if the group's rules select a person
+func (g *Group) includesPerson(person *Person) bool {
+	if g.IncludeAllPeople {
+		return true
+	}
+	for i := range g.Include {
+		if g.Include[i].matches(RuleKindPerson, person.Labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// includesSvcAcct returns true if the group's rules select a service account
+func (g *Group) includesSvcAcct(svcacct *SvcAcct) bool {
+	for i := range g.Include {
+		if g.Include[i].matches(RuleKindSvcAcct, svcacct.Labels) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ValidateSSHKey returns an error unless key is a single valid authorized_keys line
+func ValidateSSHKey(key string) error {
+	if strings.ContainsAny(key, "\r\n") {
+		return fmt.Errorf("SSH key must be a single line")
+	}
+	_, _, _, rest, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return fmt.Errorf("invalid SSH key: %w", err)
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("SSH key must be a single line")
+	}
+	return nil
+}
+
+// ParseSSHKeysFile returns the keys in the contents of an authorized_keys style file,
+// skipping blank lines and comments
+func ParseSSHKeysFile(data string) []string {
+	var keys []string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys
+}
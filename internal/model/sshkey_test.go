@@ -0,0 +1,61 @@
+package model
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestSSHKey returns a freshly generated authorized_keys line
+func newTestSSHKey(t *testing.T, comment string) string {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to convert key: %v", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment
+}
+
+func TestValidateSSHKey(t *testing.T) {
+	key := newTestSSHKey(t, "alice@laptop")
+
+	tests := []struct {
+		name        string
+		key         string
+		expectError bool
+	}{
+		{"Valid key", key, false},
+		{"Valid key with options", `from="10.0.0.0/8" ` + key, false},
+		{"Not a key", "hello world", true},
+		{"Truncated key", key[:30], true},
+		{"Two keys", key + "\n" + key, true},
+		{"Empty", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSSHKey(tt.key)
+			if tt.expectError && err == nil {
+				t.Errorf("Expected an error but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Did not expect an error but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseSSHKeysFile(t *testing.T) {
+	data := "# Alice's keys\n\nssh-ed25519 AAAA first\n  ssh-ed25519 AAAA second  \n"
+	keys := ParseSSHKeysFile(data)
+	if len(keys) != 2 || keys[0] != "ssh-ed25519 AAAA first" || keys[1] != "ssh-ed25519 AAAA second" {
+		t.Errorf("Unexpected keys: %q", keys)
+	}
+}
@@ -1,11 +1,16 @@
 package model
 
+import "time"
+
 // SvcAcct represents an LDAP service account
 type SvcAcct struct {
 	// Username (uid) - used for DN and home directory
 	// This is set from the map key in the configuration
 	Username string `toml:"-"`
 
+	// Template that supplies defaults for the fields this service account does not set (optional)
+	Template string `toml:"template,omitempty"`
+
 	// Common name (CN)
 	CN string `toml:"cn"`
 
@@ -18,6 +23,51 @@ type SvcAcct struct {
 	// POSIX attributes: UID number, GID number (optional)
 	// If set, indicates this is a POSIX account
 	Posix []int `toml:"posix,omitempty"`
+
+	// Allocate POSIX UID and GID numbers from uid_range instead of setting posix (optional)
+	AutoPosix bool `toml:"auto_posix,omitempty"`
+
+	// POSIX group whose GID number is this service account's GID number; posix then lists only the UID number (optional)
+	PrimaryGroup string `toml:"primary_group,omitempty"`
+
+	// Make this service account a member of its primary group, overriding the global primary_group_member (optional)
+	PrimaryGroupMember *bool `toml:"primary_group_member,omitempty"`
+
+	// Login shell for POSIX accounts, overriding the global default (optional)
+	LoginShell string `toml:"login_shell,omitempty"`
+
+	// Home directory for POSIX accounts, overriding home_directory_base/<uid> (optional)
+	HomeDirectory string `toml:"home_directory,omitempty"`
+
+	// Lock the account without removing it, or unlock it with false (optional)
+	Disabled *bool `toml:"disabled,omitempty"`
+
+	// Lock the account from this date, like "2026-12-31", or RFC 3339 timestamp (optional)
+	Expires string `toml:"expires,omitempty"`
+
+	// Groups to add this account to, in addition to the groups that list it in svcaccts (optional)
+	Groups []string `toml:"groups,omitempty"`
+
+	// Labels that group include rules select accounts by, as name to value (optional)
+	Labels map[string]string `toml:"labels,omitempty"`
+
+	// Additional LDAP attributes, as attribute name to values (optional)
+	Attributes map[string][]string `toml:"attributes,omitempty"`
+
+	// Additional object classes, e.g. for a custom schema (optional)
+	ExtraObjectClasses []string `toml:"extra_object_classes,omitempty"`
+
+	// Pre-hashed userPassword value, like "{SSHA512}..." (optional)
+	PasswordHash string `toml:"password_hash,omitempty"`
+
+	// File containing the pre-hashed userPassword value, relative to the config file (optional)
+	PasswordFile string `toml:"password_file,omitempty"`
+
+	// SSH public keys in authorized_keys format (optional)
+	SSHKeys []string `toml:"ssh_keys,omitempty"`
+
+	// File of additional SSH public keys in authorized_keys format, relative to the config file (optional)
+	SSHKeysFile string `toml:"ssh_keys_file,omitempty"`
 }
 
 // IsPosix returns true if the service account has POSIX attributes
@@ -40,3 +90,35 @@ func (s *SvcAcct) GetGIDNumber() int {
 	}
 	return 0
 }
+
+// IsLocked returns true if the service account is disabled or has expired at the given time
+func (s *SvcAcct) IsLocked(now time.Time) bool {
+	return s.status().locked(now)
+}
+
+// IsPrimaryGroupMember returns true if groupname is the service account's primary group and primary_group_member makes it a member.
+// The global primary_group_member is filled in by Config.ResolvePrimaryGroups.
+func (s *SvcAcct) IsPrimaryGroupMember(groupname string) bool {
+	return s.PrimaryGroup != "" && s.PrimaryGroup == groupname && s.PrimaryGroupMember != nil && *s.PrimaryGroupMember
+}
+
+// ManagesLock returns true if the service account sets disabled or expires,
+// so that its nsAccountLock follows the configuration instead of being left alone
+func (s *SvcAcct) ManagesLock() bool {
+	return s.status().managesLock()
+}
+
+// ExpiresAt returns when the service account expires, if it has a valid expiry time
+func (s *SvcAcct) ExpiresAt() (time.Time, bool) {
+	return s.status().expiresAt()
+}
+
+// GetShadowExpire returns the shadowExpire value for a POSIX service account,
+// or 0 if the service account is neither disabled nor has an expiry time
+func (s *SvcAcct) GetShadowExpire() int {
+	return s.status().shadowExpire()
+}
+
+func (s *SvcAcct) status() accountStatus {
+	return accountStatus{disabled: s.Disabled, expires: s.Expires}
+}
@@ -0,0 +1,186 @@
+package model
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Template holds defaults for the people and service accounts that name it in their template key.
+// Fields set on the account override the template.
+type Template struct {
+	// Template this one extends; its values apply wherever this one does not set them (optional)
+	Template string `toml:"template,omitempty"`
+
+	// POSIX GID number for accounts whose posix lists only a UID number, or that use auto_posix (optional)
+	GidNumber int `toml:"gidNumber,omitempty"`
+
+	// Allocate POSIX numbers for accounts that do not set posix (optional)
+	AutoPosix bool `toml:"auto_posix,omitempty"`
+
+	// Login shell for POSIX accounts (optional)
+	LoginShell string `toml:"login_shell,omitempty"`
+
+	// Directory that home directories are created under, as <base>/<uid>, overriding the global home_directory_base (optional)
+	HomeDirectoryBase string `toml:"home_directory_base,omitempty"`
+
+	// Groups to add accounts to, in addition to the account's own groups (optional)
+	Groups []string `toml:"groups,omitempty"`
+
+	// Additional LDAP attributes; an attribute set on the account replaces the template's values (optional)
+	Attributes map[string][]string `toml:"attributes,omitempty"`
+
+	// Additional object classes, in addition to the account's own (optional)
+	ExtraObjectClasses []string `toml:"extra_object_classes,omitempty"`
+}
+
+// ResolveTemplates returns the templates with the values they inherit from the templates they extend filled in.
+// It returns an error if a template extends an unknown template or inheritance forms a cycle.
+func ResolveTemplates(templates map[string]*Template) (map[string]*Template, error) {
+	resolved := make(map[string]*Template, len(templates))
+
+	var resolve func(name string, chain []string) (*Template, error)
+	resolve = func(name string, chain []string) (*Template, error) {
+		if t, ok := resolved[name]; ok {
+			return t, nil
+		}
+		for _, seen := range chain {
+			if seen == name {
+				return nil, fmt.Errorf("template inheritance cycle: %s", strings.Join(append(chain, name), " -> "))
+			}
+		}
+		template, ok := templates[name]
+		if !ok {
+			return nil, fmt.Errorf("template %s extends unknown template %s", chain[len(chain)-1], name)
+		}
+
+		result := *template
+		if template.Template != "" {
+			parent, err := resolve(template.Template, append(chain, name))
+			if err != nil {
+				return nil, err
+			}
+			result = mergeTemplate(parent, template)
+		}
+		resolved[name] = &result
+		return &result, nil
+	}
+
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := resolve(name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return resolved, nil
+}
+
+// mergeTemplate returns child with the values of parent filled in where child does not set them
+func mergeTemplate(parent, child *Template) Template {
+	result := *child
+	if result.GidNumber == 0 {
+		result.GidNumber = parent.GidNumber
+	}
+	if !result.AutoPosix {
+		result.AutoPosix = parent.AutoPosix
+	}
+	if result.LoginShell == "" {
+		result.LoginShell = parent.LoginShell
+	}
+	if result.HomeDirectoryBase == "" {
+		result.HomeDirectoryBase = parent.HomeDirectoryBase
+	}
+	result.Groups = mergeStrings(parent.Groups, child.Groups)
+	result.Attributes = mergeAttributes(parent.Attributes, child.Attributes)
+	result.ExtraObjectClasses = mergeStrings(parent.ExtraObjectClasses, child.ExtraObjectClasses)
+	return result
+}
+
+// ApplyToPerson fills in the fields of a person that it does not set from the template
+func (t *Template) ApplyToPerson(person *Person) {
+	if person.PrimaryGroup == "" {
+		person.Posix = t.applyPosix(person.Posix)
+	}
+	if !person.AutoPosix {
+		person.AutoPosix = t.AutoPosix
+	}
+	if person.LoginShell == "" {
+		person.LoginShell = t.LoginShell
+	}
+	if person.HomeDirectory == "" && t.HomeDirectoryBase != "" {
+		person.HomeDirectory = path.Join(t.HomeDirectoryBase, person.Username)
+	}
+	person.Groups = mergeStrings(t.Groups, person.Groups)
+	person.Attributes = mergeAttributes(t.Attributes, person.Attributes)
+	person.ExtraObjectClasses = mergeStrings(t.ExtraObjectClasses, person.ExtraObjectClasses)
+}
+
+// ApplyToSvcAcct fills in the fields of a service account that it does not set from the template
+func (t *Template) ApplyToSvcAcct(svcacct *SvcAcct) {
+	if svcacct.PrimaryGroup == "" {
+		svcacct.Posix = t.applyPosix(svcacct.Posix)
+	}
+	if !svcacct.AutoPosix {
+		svcacct.AutoPosix = t.AutoPosix
+	}
+	if svcacct.LoginShell == "" {
+		svcacct.LoginShell = t.LoginShell
+	}
+	if svcacct.HomeDirectory == "" && t.HomeDirectoryBase != "" {
+		svcacct.HomeDirectory = path.Join(t.HomeDirectoryBase, svcacct.Username)
+	}
+	svcacct.Groups = mergeStrings(t.Groups, svcacct.Groups)
+	svcacct.Attributes = mergeAttributes(t.Attributes, svcacct.Attributes)
+	svcacct.ExtraObjectClasses = mergeStrings(t.ExtraObjectClasses, svcacct.ExtraObjectClasses)
+}
+
+// applyPosix adds the template's GID number to a posix list that only has a UID number.
+// Accounts with a primary group get their GID number from the group instead.
+func (t *Template) applyPosix(posix []int) []int {
+	if len(posix) == 1 && t.GidNumber != 0 {
+		return []int{posix[0], t.GidNumber}
+	}
+	return posix
+}
+
+// mergeStrings returns the values of base followed by the values of extra that are not already in base
+func mergeStrings(base, extra []string) []string {
+	if len(base) == 0 {
+		return extra
+	}
+	result := append([]string(nil), base...)
+	for _, value := range extra {
+		if !slices.Contains(result, value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// mergeAttributes returns the attributes of base, with each attribute set in override replacing it.
+// Attribute names are compared case-insensitively.
+func mergeAttributes(base, override map[string][]string) map[string][]string {
+	if len(base) == 0 {
+		return override
+	}
+	result := make(map[string][]string, len(base)+len(override))
+	for name, values := range base {
+		result[name] = values
+	}
+	for name, values := range override {
+		for existing := range result {
+			if strings.EqualFold(existing, name) {
+				delete(result, existing)
+			}
+		}
+		result[name] = values
+	}
+	return result
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveTemplates(t *testing.T) {
+	templates := map[string]*Template{
+		"base": {
+			LoginShell:         "/bin/zsh",
+			GidNumber:          100,
+			ExtraObjectClasses: []string{"extensibleObject"},
+			Attributes:         map[string][]string{"o": {"Example"}, "ou": {"Staff"}},
+		},
+		"employee": {
+			Template:   "base",
+			GidNumber:  200,
+			Attributes: map[string][]string{"OU": {"Employees"}},
+		},
+		"engineer": {
+			Template: "employee",
+			Groups:   []string{"engineering"},
+		},
+	}
+
+	resolved, err := ResolveTemplates(templates)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	engineer := resolved["engineer"]
+	if engineer.LoginShell != "/bin/zsh" {
+		t.Errorf("Expected the login shell from base, got %q", engineer.LoginShell)
+	}
+	if engineer.GidNumber != 200 {
+		t.Errorf("Expected the GID number from employee, got %d", engineer.GidNumber)
+	}
+	// Attributes are replaced by name, ignoring case
+	expectedAttributes := map[string][]string{"o": {"Example"}, "OU": {"Employees"}}
+	if !reflect.DeepEqual(engineer.Attributes, expectedAttributes) {
+		t.Errorf("Expected attributes %v, got %v", expectedAttributes, engineer.Attributes)
+	}
+	if !reflect.DeepEqual(engineer.ExtraObjectClasses, []string{"extensibleObject"}) {
+		t.Errorf("Expected the object classes from base, got %v", engineer.ExtraObjectClasses)
+	}
+
+	// The templates themselves are not modified
+	if templates["engineer"].LoginShell != "" {
+		t.Error("ResolveTemplates must not modify its input")
+	}
+}
+
+func TestResolveTemplatesErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates map[string]*Template
+	}{
+		{"Self cycle", map[string]*Template{"a": {Template: "a"}}},
+		{"Cycle", map[string]*Template{"a": {Template: "b"}, "b": {Template: "c"}, "c": {Template: "a"}}},
+		{"Unknown parent", map[string]*Template{"a": {Template: "missing"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ResolveTemplates(tt.templates); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}